@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dustin/go-humanize"
+	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/loadtest"
+	"github.com/tckz/go-gcp-playground/internal/log"
+	vh "github.com/tckz/vegetahelper"
+	"go.uber.org/zap"
+)
+
+var (
+	myName  = filepath.Base(os.Args[0])
+	logger  *zap.SugaredLogger
+	version string
+)
+
+var (
+	optLoadTest       = loadtest.NewOptions(flag.CommandLine)
+	optLogLevel       = flag.String("log-level", "info", "info|warn|error")
+	optQueries        = &queriesFlag{}
+	optParams         = flag.String("params", "", "path/to/params.jsonl, each line is an object of query parameters. The SQL of --query is not a text template; only its @name parameters vary by hit")
+	optParamOrder     = flag.String("param-order", "random", "random|sequential")
+	optLocation       = flag.String("location", "", "location of dataset")
+	optUseCache       = flag.Bool("use-cache", true, "use query cache")
+	optMaxBytesBilled = flag.Int64("max-bytes-billed", 0, "MaxBytesBilled of each query [0 = project default]")
+	optJobOutput      = flag.String("job-output", "", "path/to/jobs.jsonl, statistics of each job such as slotMillis [empty = --output + .jobs.jsonl, jobs.jsonl if --output is stdout, none = not written]")
+)
+
+func init() {
+	godotenv.Load()
+
+	flag.Var(optQueries, "query", "path/to/query.sql, fixed SQL with @name parameters given from --params, can be specified multiple times")
+}
+
+// queryStats --queryごとの集計
+type queryStats struct {
+	hits           int64
+	failed         int64
+	cacheHits      int64
+	slotMillis     int64
+	bytesProcessed int64
+	// jobDuration ジョブのEndTime-StartTimeの合計
+	jobDuration time.Duration
+}
+
+// jobResult 1回のクエリの結果。--outputのlatencyはjob.Waitのポーリング間隔も含むエンドツーエンドの時間なので、
+// BigQuery側で実行にかかった時間はこちらに残す。
+// vegetahelper.HitResultはheaderやbodyを持てず、--outputにはbytesProcessedをBytesInとして入れるのが限度なので
+// slotMillisなどはこのファイルにしか残らない
+type jobResult struct {
+	Timestamp time.Time `json:"timestamp"`
+	Query     string    `json:"query"`
+	JobID     string    `json:"jobId,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	// Latency クライアントから見たRunからWaitが返るまでの時間
+	Latency time.Duration `json:"latency"`
+	// JobDuration ジョブのStartTimeからEndTimeまで
+	JobDuration         time.Duration `json:"jobDuration,omitempty"`
+	CacheHit            bool          `json:"cacheHit,omitempty"`
+	SlotMillis          int64         `json:"slotMillis,omitempty"`
+	TotalBytesProcessed int64         `json:"totalBytesProcessed,omitempty"`
+}
+
+// jobWriter goroutine safe。nilなら何も書かない
+type jobWriter struct {
+	mu  sync.Mutex
+	fp  *os.File
+	bw  *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+// jobOutputPath --job-outputの指定がなければ--outputの隣に書く
+func jobOutputPath(jobOutput, output string) string {
+	switch {
+	case jobOutput == "none":
+		return ""
+	case jobOutput != "":
+		return jobOutput
+	case output == "stdout":
+		return "jobs.jsonl"
+	}
+	return output + ".jobs.jsonl"
+}
+
+func newJobWriter(path string) (*jobWriter, error) {
+	fp, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Create: %w", err)
+	}
+	bw := bufio.NewWriter(fp)
+	return &jobWriter{fp: fp, bw: bw, enc: json.NewEncoder(bw)}, nil
+}
+
+func (w *jobWriter) Write(r *jobResult) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = w.enc.Encode(r)
+	}
+}
+
+// Close 書き込み中に起きたエラーがあればそれを返す
+func (w *jobWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.bw.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if err := w.fp.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+type statsMap struct {
+	mu    sync.Mutex
+	stats map[string]*queryStats
+}
+
+func (m *statsMap) update(name string, f func(s *queryStats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.stats[name]
+	if !ok {
+		s = &queryStats{}
+		m.stats[name] = s
+	}
+	f(s)
+}
+
+func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+
+	logger.Infof("ver=%s, args=%s", version, os.Args)
+	defer logger.Infof("done")
+
+	if err := optLoadTest.Validate(); err != nil {
+		logger.Fatalf("*** %v", err)
+	}
+
+	if len(optQueries.values) == 0 {
+		logger.Fatalf("*** --query must be specified.")
+	}
+
+	queries, err := loadQueries(optQueries.values)
+	if err != nil {
+		logger.Fatalf("*** loadQueries: %v", err)
+	}
+	params, err := loadParams(*optParams, *optParamOrder)
+	if err != nil {
+		logger.Fatalf("*** loadParams: %v", err)
+	}
+	logger.Infof("queries=%d, params=%d", len(queries), params.Len())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pjID := os.Getenv("PROJECT_ID")
+
+	client, err := bigquery.NewClient(ctx, pjID)
+	if err != nil {
+		logger.Fatalf("*** bigquery.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	var jobs *jobWriter
+	if path := jobOutputPath(*optJobOutput, *optLoadTest.Output); path != "" {
+		jobs, err = newJobWriter(path)
+		if err != nil {
+			logger.Fatalf("*** newJobWriter: %v", err)
+		}
+		logger.Infof("job-output=%s", path)
+	}
+
+	stats := &statsMap{stats: map[string]*queryStats{}}
+	runErr := optLoadTest.Run(ctx, logger, "bq", func(ctx context.Context) (result *vh.HitResult, retErr error) {
+		qt := queries[rand.IntN(len(queries))]
+		jr := &jobResult{Timestamp: time.Now(), Query: qt.name}
+		defer func() {
+			jr.Latency = time.Since(jr.Timestamp)
+			if retErr != nil {
+				jr.Error = retErr.Error()
+				stats.update(qt.name, func(s *queryStats) {
+					s.hits++
+					s.failed++
+				})
+			}
+			jobs.Write(jr)
+		}()
+
+		qps, err := qt.Parameters(params.Pick())
+		if err != nil {
+			return nil, err
+		}
+
+		q := client.Query(qt.sql)
+		q.Parameters = qps
+		q.Location = *optLocation
+		q.DisableQueryCache = !*optUseCache
+		q.MaxBytesBilled = *optMaxBytesBilled
+
+		job, err := q.Run(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("q.Run: %w", err)
+		}
+		jr.JobID = job.ID()
+
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("job.Wait: %w", err)
+		}
+		if err := status.Err(); err != nil {
+			return nil, fmt.Errorf("status.Err: %w", err)
+		}
+
+		var slotMillis int64
+		var cacheHit bool
+		if qs, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+			slotMillis = qs.SlotMillis
+			cacheHit = qs.CacheHit
+		}
+		bytesProcessed := status.Statistics.TotalBytesProcessed
+		jobDuration := status.Statistics.EndTime.Sub(status.Statistics.StartTime)
+		jr.CacheHit = cacheHit
+		jr.SlotMillis = slotMillis
+		jr.TotalBytesProcessed = bytesProcessed
+		jr.JobDuration = jobDuration
+
+		stats.update(qt.name, func(s *queryStats) {
+			s.hits++
+			if cacheHit {
+				s.cacheHits++
+			}
+			s.slotMillis += slotMillis
+			s.bytesProcessed += bytesProcessed
+			s.jobDuration += jobDuration
+		})
+
+		// vegetaのレポートのBytes Inでスキャン量を見られるようにする
+		return &vh.HitResult{
+			Code:      http.StatusOK,
+			RecvBytes: uint64(bytesProcessed),
+		}, nil
+	})
+	if runErr != nil {
+		logger.Errorf("*** Run: %v", runErr)
+	}
+
+	names := make([]string, 0, len(stats.stats))
+	for k := range stats.stats {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var total queryStats
+	for _, k := range names {
+		s := stats.stats[k]
+		logStats(k, s)
+		total.hits += s.hits
+		total.failed += s.failed
+		total.cacheHits += s.cacheHits
+		total.slotMillis += s.slotMillis
+		total.bytesProcessed += s.bytesProcessed
+		total.jobDuration += s.jobDuration
+	}
+	logStats("total", &total)
+
+	failed := runErr != nil
+	if err := jobs.Close(); err != nil {
+		logger.Errorf("*** jobs.Close: %v", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func logStats(name string, s *queryStats) {
+	var ratio float64
+	// 失敗したものはキャッシュヒットの判定ができないので除く
+	if n := s.hits - s.failed; n > 0 {
+		ratio = float64(s.cacheHits) / float64(n)
+	}
+	var meanJob time.Duration
+	if n := s.hits - s.failed; n > 0 {
+		meanJob = s.jobDuration / time.Duration(n)
+	}
+	// latencyのレポートはポーリング間隔を含むのでBigQuery側の実行時間はmeanJobDurationで見る
+	logger.Infof("query=%s, hits=%d, failed=%d, cacheHitRatio=%.3f, slotMillis=%d, bytesProcessed=%s, meanJobDuration=%s",
+		name, s.hits, s.failed, ratio, s.slotMillis, humanize.Bytes(uint64(s.bytesProcessed)), meanJob)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestJobOutputPath(t *testing.T) {
+	tests := []struct {
+		jobOutput, output, want string
+	}{
+		{jobOutput: "", output: "out/results.bin", want: "out/results.bin.jobs.jsonl"},
+		{jobOutput: "", output: "stdout", want: "jobs.jsonl"},
+		{jobOutput: "j.jsonl", output: "stdout", want: "j.jsonl"},
+		{jobOutput: "none", output: "results.bin", want: ""},
+	}
+	for _, tt := range tests {
+		if got := jobOutputPath(tt.jobOutput, tt.output); got != tt.want {
+			t.Errorf("jobOutputPath(%q, %q)=%q, want %q", tt.jobOutput, tt.output, got, tt.want)
+		}
+	}
+}
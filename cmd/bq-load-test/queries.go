@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// queriesFlag 複数回指定できるフラグ
+type queriesFlag struct {
+	values []string
+}
+
+func (f *queriesFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *queriesFlag) Set(s string) error {
+	f.values = append(f.values, s)
+	return nil
+}
+
+// reParam @nameの形の名前付きパラメータ。@@から始まるシステム変数は除く
+var reParam = regexp.MustCompile(`(^|[^@\w])@(\w+)`)
+
+type queryFile struct {
+	name string
+	sql  string
+	// params SQLが参照するパラメータ名。BigQueryと同じく大文字小文字を区別しないので小文字にしておく
+	params []string
+}
+
+func loadQueries(paths []string) ([]*queryFile, error) {
+	ret := make([]*queryFile, 0, len(paths))
+	for _, e := range paths {
+		b, err := os.ReadFile(e)
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile: %w", err)
+		}
+		q := &queryFile{name: filepath.Base(e), sql: string(b)}
+		seen := map[string]bool{}
+		for _, m := range reParam.FindAllStringSubmatch(q.sql, -1) {
+			n := strings.ToLower(m[2])
+			if !seen[n] {
+				seen[n] = true
+				q.params = append(q.params, n)
+			}
+		}
+		sort.Strings(q.params)
+		ret = append(ret, q)
+	}
+	return ret, nil
+}
+
+// Parameters paramsのうちSQLが参照するものだけを渡す。参照しているのにparamsにないものはエラー
+func (q *queryFile) Parameters(params map[string]interface{}) ([]bigquery.QueryParameter, error) {
+	if len(q.params) == 0 {
+		return nil, nil
+	}
+	lower := make(map[string]string, len(params))
+	for k := range params {
+		lower[strings.ToLower(k)] = k
+	}
+	ret := make([]bigquery.QueryParameter, 0, len(q.params))
+	for _, n := range q.params {
+		k, ok := lower[n]
+		if !ok {
+			return nil, fmt.Errorf("%s: parameter @%s is not given", q.name, n)
+		}
+		ret = append(ret, bigquery.QueryParameter{Name: k, Value: params[k]})
+	}
+	return ret, nil
+}
+
+// paramSource クエリパラメータをファイルから順番またはランダムに選ぶ
+type paramSource struct {
+	params []map[string]interface{}
+	random bool
+	next   uint64
+}
+
+// loadParams 1行1オブジェクトのJSON Lines。pathが空ならパラメータなし
+func loadParams(path string, order string) (*paramSource, error) {
+	ps := &paramSource{}
+	switch order {
+	case "sequential":
+	case "random":
+		ps.random = true
+	default:
+		return nil, fmt.Errorf("unknown param order: %s", order)
+	}
+
+	if path == "" {
+		return ps, nil
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer fp.Close()
+
+	sc := bufio.NewScanner(fp)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for ln := 1; sc.Scan(); ln++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		m, err := parseParams(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, ln, err)
+		}
+		ps.params = append(ps.params, m)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("Scan: %w", err)
+	}
+	return ps, nil
+}
+
+// parseParams JSONの値をQueryParameter.Valueに渡せる型にする
+func parseParams(line []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	// 整数とFLOAT64を区別する
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("json.Decode: %w", err)
+	}
+	for k, v := range m {
+		pv, err := paramValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		m[k] = pv
+	}
+	return m, nil
+}
+
+func paramValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		// 型が分からないのでSTRINGのNULLにする
+		return bigquery.NullString{}, nil
+	case bool, string:
+		return v, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	case []interface{}:
+		return arrayValue(v)
+	default:
+		return nil, fmt.Errorf("unsupported parameter type: %T", v)
+	}
+}
+
+// arrayValue 要素の型が揃った配列だけを受け付ける。整数と小数が混ざっていればFLOAT64の配列にする
+func arrayValue(l []interface{}) (interface{}, error) {
+	var ints []int64
+	var floats []float64
+	var strs []string
+	var bools []bool
+	for _, e := range l {
+		pv, err := paramValue(e)
+		if err != nil {
+			return nil, err
+		}
+		switch pv := pv.(type) {
+		case int64:
+			ints = append(ints, pv)
+			floats = append(floats, float64(pv))
+		case float64:
+			floats = append(floats, pv)
+		case string:
+			strs = append(strs, pv)
+		case bool:
+			bools = append(bools, pv)
+		default:
+			return nil, fmt.Errorf("unsupported array element: %v", e)
+		}
+	}
+	switch len(l) {
+	case len(ints):
+		return ints, nil
+	case len(floats):
+		return floats, nil
+	case len(strs):
+		return strs, nil
+	case len(bools):
+		return bools, nil
+	default:
+		return nil, fmt.Errorf("array elements must have the same type: %v", l)
+	}
+}
+
+func (ps *paramSource) Len() int {
+	return len(ps.params)
+}
+
+func (ps *paramSource) Pick() map[string]interface{} {
+	if len(ps.params) == 0 {
+		return nil
+	}
+	if ps.random {
+		return ps.params[rand.IntN(len(ps.params))]
+	}
+	n := atomic.AddUint64(&ps.next, 1) - 1
+	return ps.params[n%uint64(len(ps.params))]
+}
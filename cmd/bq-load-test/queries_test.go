@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestLoadQueriesParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "q.sql")
+	sql := "SELECT @@project_id, * FROM t WHERE a = @A AND b IN UNNEST(@b) AND c = @a AND mail = 'x@y'"
+	if err := os.WriteFile(path, []byte(sql), 0644); err != nil {
+		t.Fatal(err)
+	}
+	qs, err := loadQueries([]string{path})
+	if err != nil {
+		t.Fatalf("loadQueries: %v", err)
+	}
+	q := qs[0]
+	if q.name != "q.sql" || q.sql != sql {
+		t.Errorf("name=%s, sql=%s", q.name, q.sql)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(q.params, want) {
+		t.Errorf("params=%v, want %v", q.params, want)
+	}
+
+	got, err := q.Parameters(map[string]interface{}{"a": int64(1), "B": []string{"x"}, "unused": "z"})
+	if err != nil {
+		t.Fatalf("Parameters: %v", err)
+	}
+	want := []bigquery.QueryParameter{
+		{Name: "a", Value: int64(1)},
+		{Name: "B", Value: []string{"x"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parameters=%v, want %v", got, want)
+	}
+
+	if _, err := q.Parameters(map[string]interface{}{"a": int64(1)}); err == nil || !strings.Contains(err.Error(), "@b") {
+		t.Errorf("err=%v, want missing @b", err)
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "scalars",
+			line: `{"i": 1, "f": 1.5, "s": "it's", "b": true, "n": null}`,
+			want: map[string]interface{}{
+				"i": int64(1),
+				"f": 1.5,
+				"s": "it's",
+				"b": true,
+				"n": bigquery.NullString{},
+			},
+		},
+		{
+			name: "arrays",
+			line: `{"i": [1, 2], "f": [1, 2.5], "s": ["a"], "b": [false]}`,
+			want: map[string]interface{}{
+				"i": []int64{1, 2},
+				"f": []float64{1, 2.5},
+				"s": []string{"a"},
+				"b": []bool{false},
+			},
+		},
+		{
+			name:    "mixed array",
+			line:    `{"a": [1, "x"]}`,
+			wantErr: "same type",
+		},
+		{
+			name:    "object",
+			line:    `{"a": {"b": 1}}`,
+			wantErr: "unsupported parameter type",
+		},
+		{
+			name:    "not json",
+			line:    `{"a": `,
+			wantErr: "json.Decode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseParams([]byte(tt.line))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseParams: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got=%#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/datastore"
+)
+
+// savedCursor 中断したところから再開するためにファイルに残すもの
+type savedCursor struct {
+	// Query 別のクエリのカーソルを誤って使わないように対象を記録しておく
+	Query   string `json:"query"`
+	Cursor  string `json:"cursor"`
+	Deleted int64  `json:"deleted"`
+}
+
+// loadCursor ファイルがなければnil
+func loadCursor(path string, query string) (*savedCursor, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	var c savedCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	if c.Query != query {
+		return nil, fmt.Errorf("cursor in %s is for another query: %s", path, c.Query)
+	}
+	if _, err := datastore.DecodeCursor(c.Cursor); err != nil {
+		return nil, fmt.Errorf("datastore.DecodeCursor: %w", err)
+	}
+	return &c, nil
+}
+
+// saveCursor 書きかけのファイルが残らないように別名で書いてからrenameする
+func saveCursor(path string, c *savedCursor) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("os.Rename: %w", err)
+	}
+	return nil
+}
+
+// cursorTracker 削除の終わったバッチのうち先頭から連続している分のカーソルだけを保存する。
+// バッチは並行して削除されるので、読んだ位置のカーソルを保存すると未削除のキーを飛ばしてしまう
+type cursorTracker struct {
+	path    string
+	query   string
+	next    int64
+	done    map[int64]datastore.Cursor
+	deleted int64
+}
+
+func newCursorTracker(path, query string, deleted int64) *cursorTracker {
+	return &cursorTracker{
+		path:    path,
+		query:   query,
+		done:    map[int64]datastore.Cursor{},
+		deleted: deleted,
+	}
+}
+
+// Done seq番目のバッチの削除が終わった
+func (t *cursorTracker) Done(seq int64, n int, c datastore.Cursor) error {
+	t.deleted += int64(n)
+	t.done[seq] = c
+
+	var last *datastore.Cursor
+	for {
+		c, ok := t.done[t.next]
+		if !ok {
+			break
+		}
+		delete(t.done, t.next)
+		t.next++
+		last = &c
+	}
+	if last == nil || t.path == "" {
+		return nil
+	}
+	return saveCursor(t.path, &savedCursor{Query: t.query, Cursor: last.String(), Deleted: t.deleted})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+)
+
+func testCursor(t *testing.T, s string) datastore.Cursor {
+	t.Helper()
+	c, err := datastore.DecodeCursor(base64.RawURLEncoding.EncodeToString([]byte(s)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCursorTrackerDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cursor.json")
+	tr := newCursorTracker(path, "q", 100)
+
+	tests := []struct {
+		seq int64
+		n   int
+		// wantCursor 保存されているはずのカーソル。空なら保存されていない
+		wantCursor  string
+		wantDeleted int64
+	}{
+		// 先頭が終わるまでは保存しない
+		{seq: 1, n: 10},
+		{seq: 2, n: 10},
+		// 0が終われば2まで連続するので2のカーソル
+		{seq: 0, n: 10, wantCursor: "c2", wantDeleted: 130},
+		// 4は3が終わるまで保存しない
+		{seq: 4, n: 5, wantCursor: "c2", wantDeleted: 130},
+		{seq: 3, n: 5, wantCursor: "c4", wantDeleted: 140},
+		{seq: 5, n: 1, wantCursor: "c5", wantDeleted: 141},
+	}
+	for _, tt := range tests {
+		if err := tr.Done(tt.seq, tt.n, testCursor(t, "c"+string(rune('0'+tt.seq)))); err != nil {
+			t.Fatalf("Done(%d): %v", tt.seq, err)
+		}
+		saved, err := loadCursor(path, "q")
+		if err != nil {
+			t.Fatalf("loadCursor: %v", err)
+		}
+		if tt.wantCursor == "" {
+			if saved != nil {
+				t.Errorf("after seq=%d, saved=%+v, want none", tt.seq, saved)
+			}
+			continue
+		}
+		if saved == nil {
+			t.Fatalf("after seq=%d, not saved", tt.seq)
+		}
+		if want := testCursor(t, tt.wantCursor).String(); saved.Cursor != want || saved.Deleted != tt.wantDeleted {
+			t.Errorf("after seq=%d, saved=%+v, want cursor=%s, deleted=%d", tt.seq, saved, want, tt.wantDeleted)
+		}
+	}
+	if len(tr.done) != 0 {
+		t.Errorf("done=%v, want empty", tr.done)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("tmp file must not remain: %v", err)
+	}
+}
+
+func TestCursorTrackerDoneWithoutPath(t *testing.T) {
+	tr := newCursorTracker("", "q", 0)
+	if err := tr.Done(0, 1, testCursor(t, "c0")); err != nil {
+		t.Fatalf("Done: %v", err)
+	}
+	if tr.next != 1 || tr.deleted != 1 {
+		t.Errorf("next=%d, deleted=%d", tr.next, tr.deleted)
+	}
+}
+
+func TestCursorTrackerDoneSaveError(t *testing.T) {
+	tr := newCursorTracker(filepath.Join(t.TempDir(), "no", "such", "dir", "cursor.json"), "q", 0)
+	if err := tr.Done(0, 1, testCursor(t, "c0")); err == nil {
+		t.Errorf("Done must fail when the cursor cannot be saved")
+	}
+}
+
+func TestLoadCursor(t *testing.T) {
+	dir := t.TempDir()
+	if c, err := loadCursor(filepath.Join(dir, "missing.json"), "q"); c != nil || err != nil {
+		t.Errorf("missing file: %v, %v, want nil, nil", c, err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "other query", content: `{"query": "other", "cursor": ""}`, wantErr: "another query"},
+		{name: "broken json", content: `{"query":`, wantErr: "json.Unmarshal"},
+		{name: "bad cursor", content: `{"query": "q", "cursor": "!!"}`, wantErr: "DecodeCursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadCursor(path, "q")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
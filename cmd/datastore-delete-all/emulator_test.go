@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/dsutil/dstest"
+)
+
+func TestMain(m *testing.M) {
+	dstest.Main(m, main)
+}
+
+func TestDeleteAllEmulator(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range dstest.Cases() {
+		t.Run(c.Name, func(t *testing.T) {
+			cl := dstest.Client(t, c.Database)
+			kind := dstest.Unique("Item")
+			other := dstest.Unique("Other")
+
+			var keys []*datastore.Key
+			var props []datastore.PropertyList
+			for _, k := range []*datastore.Key{c.Key(kind, "a", nil), c.Key(kind, "b", nil), c.Key(kind, "c", nil), c.Key(other, "d", nil)} {
+				keys = append(keys, k)
+				props = append(props, datastore.PropertyList{{Name: "Name", Value: k.Name}})
+			}
+			if _, err := cl.PutMulti(ctx, keys, props); err != nil {
+				t.Fatalf("PutMulti: %v", err)
+			}
+
+			count := func(t *testing.T, kind string) int {
+				t.Helper()
+				n, err := cl.Count(ctx, datastore.NewQuery(kind).Namespace(c.Namespace).KeysOnly())
+				if err != nil {
+					t.Fatalf("Count: %v", err)
+				}
+				return n
+			}
+
+			if _, err := dstest.Run(t, append(c.Args(), "--kind", kind, "--dry-run")...); err != nil {
+				t.Fatalf("Run --dry-run: %v", err)
+			}
+			if n := count(t, kind); n != 3 {
+				t.Fatalf("--dry-run deleted entities: %d remain", n)
+			}
+			// --yesがなく確認に答えられなければ消さずに0以外で終わる
+			if _, err := dstest.Run(t, append(c.Args(), "--kind", kind)...); err == nil {
+				t.Errorf("Run without --yes must fail")
+			}
+			if n := count(t, kind); n != 3 {
+				t.Fatalf("deleted without confirmation: %d remain", n)
+			}
+
+			cursor := filepath.Join(t.TempDir(), "cursor.json")
+			if _, err := dstest.Run(t, append(c.Args(), "--kind", kind, "--yes", "--cursor-file", cursor)...); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if n := count(t, kind); n != 0 {
+				t.Errorf("%d entities remain", n)
+			}
+			if n := count(t, other); n != 1 {
+				t.Errorf("another kind was deleted: %d remain", n)
+			}
+			// 最後まで消し終えたらカーソルのファイルは消える
+			if _, err := os.Stat(cursor); !os.IsNotExist(err) {
+				t.Errorf("cursor file remains: %v", err)
+			}
+		})
+	}
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
 	"github.com/tckz/go-gcp-playground/internal/log"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 	"google.golang.org/api/iterator"
 )
 
@@ -25,20 +32,55 @@ var (
 
 var (
 	optLogLevel   = flag.String("log-level", "info", "info|warn|error")
-	optNameSpace  = flag.String("ns", "", "namespace")
-	optKind       = flag.String("kind", "mykind", "namespace")
+	optClient     = dsutil.NewClientOptions(flag.CommandLine)
+	optQuery      = dsutil.NewQueryOptions(flag.CommandLine)
 	optDisplayKey = flag.Bool("display-key", false, "")
+	optDryRun     = flag.Bool("dry-run", false, "count entities to be deleted without deleting")
+	optYes        = flag.Bool("yes", false, "delete without confirmation")
+	optWorkers    = flag.Int("workers", 8, "Number of concurrent DeleteMulti")
+	optRate       = flag.Float64("rate", 0, "Max entities deleted per second [0 = unlimited]")
+	optCursorFile = flag.String("cursor-file", "", "path/to/cursor.json, saves progress and resumes from it if exists, removed when completed [empty = not saved]")
+	optLogStep    = flag.Int64("log-step", 10000, "progress interval of counting")
 )
 
 func init() {
 	godotenv.Load()
+}
 
-	flag.Parse()
+// deleteBatch cursorはkeysの最後のキーの次を指す
+type deleteBatch struct {
+	seq    int64
+	keys   []*datastore.Key
+	cursor datastore.Cursor
+}
 
-	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+type deleteReport struct {
+	seq    int64
+	n      int
+	cursor datastore.Cursor
+}
+
+// confirm inからyが入力されたらtrue。答えを読めなければエラー
+func confirm(in io.Reader, msg string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", msg)
+	s, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || s == "") {
+		return false, fmt.Errorf("no answer, specify --yes to delete without confirmation: %w", err)
+	}
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s == "y" || s == "yes", nil
+}
+
+// isTerminal パイプやファイルからの入力で確認したことにしない
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
 
 func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
 	logger.Infof("ver=%s, args=%s", version, os.Args)
 	{
 		now := time.Now()
@@ -47,20 +89,86 @@ func main() {
 		}()
 	}
 
+	if *optWorkers <= 0 {
+		logger.Fatalf("*** --workers must be > 0.")
+	}
+
+	// 型にマップするとdatastore側にある列がtype側にないとエラーになってしまってdelete目的の場合にいちいち合わせるのが面倒なのでkeyのみ扱う
+	q, err := optQuery.Query()
+	if err != nil {
+		logger.Fatalf("*** optQuery.Query: %v", err)
+	}
+	q = q.KeysOnly()
+
+	database, err := optClient.DatabaseOf(optQuery.Database())
+	if err != nil {
+		logger.Fatalf("*** %v", err)
+	}
+	// 同じクエリでもデータベースが違えばカーソルは使えない
+	target := fmt.Sprintf("database=%s, %s", database, optQuery)
+
+	var deletedBefore int64
+	if *optCursorFile != "" {
+		saved, err := loadCursor(*optCursorFile, target)
+		if err != nil {
+			logger.Fatalf("*** loadCursor: %v", err)
+		}
+		if saved != nil {
+			c, _ := datastore.DecodeCursor(saved.Cursor)
+			q = q.Start(c)
+			deletedBefore = saved.Deleted
+			logger.Infof("resume from %s, %d entities deleted before", *optCursorFile, saved.Deleted)
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	pjID := os.Getenv("PROJECT_ID")
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+		s := <-sig
+		logger.Infof("Received signal: %v", s)
+		cancel()
+	}()
 
-	cl, err := datastore.NewClient(context.Background(), pjID)
+	cl, err := optClient.NewClient(context.Background(), "", database)
 	if err != nil {
-		logger.Fatalf("*** datastore.NewClient: %v", err)
+		logger.Fatalf("*** optClient.NewClient: %v", err)
 	}
 	defer cl.Close()
 
+	if *optDryRun || !*optYes {
+		n, err := dsutil.CountKeys(ctx, cl, q, *optLogStep, func(n int64) {
+			logger.Infof("counted=%d", n)
+		})
+		if err != nil {
+			logger.Fatalf("*** dsutil.CountKeys: %v", err)
+		}
+		if *optDryRun {
+			logger.Infof("%d entities would be deleted, %s", n, target)
+			return
+		}
+		if n == 0 {
+			logger.Infof("no entities to delete")
+			return
+		}
+		if !isTerminal(os.Stdin) {
+			logger.Fatalf("*** stdin is not a terminal, specify --yes to delete without confirmation.")
+		}
+		ok, err := confirm(os.Stdin, fmt.Sprintf("Delete %d entities, %s?", n, target))
+		if err != nil {
+			logger.Fatalf("*** confirm: %v", err)
+		}
+		if !ok {
+			logger.Fatalf("*** canceled.")
+		}
+	}
+
 	const MaxDeleteItem = 500
-	chKey := make(chan *datastore.Key, MaxDeleteItem)
-	chReport := make(chan int)
+	chBatch := make(chan *deleteBatch, *optWorkers)
+	chReport := make(chan deleteReport)
 
 	egReport, ctxReport := errgroup.WithContext(ctx)
 	egReport.Go(func() (retErr error) {
@@ -69,6 +177,7 @@ func main() {
 				cancel()
 			}
 		}()
+		tracker := newCursorTracker(*optCursorFile, target, deletedBefore)
 		sum := 0
 		for {
 			select {
@@ -79,86 +188,130 @@ func main() {
 					logger.Infof("%d entities deleted", sum)
 					return nil
 				}
-				sum = sum + e
+				sum = sum + e.n
+				if err := tracker.Done(e.seq, e.n, e.cursor); err != nil {
+					return err
+				}
 			}
 		}
 	})
 
+	// バーストを1バッチ分にしておけば1回のDeleteMultiで待ちきれないことはない
+	var limiter *rate.Limiter
+	if *optRate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(*optRate), MaxDeleteItem)
+	}
+
 	egDel, ctxDel := errgroup.WithContext(ctx)
-	for i := 0; i < 8; i++ {
+	for i := 0; i < *optWorkers; i++ {
 		egDel.Go(func() (retErr error) {
 			defer func() {
 				if retErr != nil {
 					cancel()
 				}
 			}()
-			keys := make([]*datastore.Key, 0, MaxDeleteItem)
-			flush := func() error {
-				if len(keys) == 0 {
-					return nil
-				}
-				l := len(keys)
-				now := time.Now()
-				logger.Infof("Try to delete %d keys", l)
-				if err := cl.DeleteMulti(ctxDel, keys); err != nil {
-					return err
-				}
-				logger.Infof("Deleted %d keys, dur=%s", l, time.Since(now))
-				keys = keys[:0]
-				chReport <- l
-				return nil
-			}
-
 			for {
 				select {
 				case <-ctxDel.Done():
 					return ctxDel.Err()
-				case e, ok := <-chKey:
+				case b, ok := <-chBatch:
 					if !ok {
-						if err := flush(); err != nil {
-							return err
-						}
 						return nil
 					}
-					keys = append(keys, e)
-					if len(keys) == MaxDeleteItem {
-						if err := flush(); err != nil {
+					l := len(b.keys)
+					if limiter != nil {
+						if err := limiter.WaitN(ctxDel, l); err != nil {
 							return err
 						}
 					}
+					now := time.Now()
+					logger.Infof("Try to delete %d keys", l)
+					if err := cl.DeleteMulti(ctxDel, b.keys); err != nil {
+						return err
+					}
+					logger.Infof("Deleted %d keys, dur=%s", l, time.Since(now))
+					select {
+					case <-ctxDel.Done():
+						return ctxDel.Err()
+					case chReport <- deleteReport{seq: b.seq, n: l, cursor: b.cursor}:
+					}
 				}
 			}
 		})
 	}
 
-	// 型にマップするとdatastore側にある列がtype側にないとエラーになってしまってdelete目的の場合にいちいち合わせるのが面倒なのでkeyのみ扱う
-	q := datastore.NewQuery(*optKind).Namespace(*optNameSpace).KeysOnly()
+	readErr := readKeys(ctxDel, cl, q, database, MaxDeleteItem, chBatch)
+	close(chBatch)
+
+	logger.Infof("Waiting for deleting done")
+	delErr := egDel.Wait()
+	close(chReport)
+	reportErr := egReport.Wait()
+
+	failed := false
+	if readErr != nil && !errors.Is(readErr, context.Canceled) {
+		logger.Errorf("readKeys: %v", readErr)
+		failed = true
+	}
+	if delErr != nil && !errors.Is(delErr, context.Canceled) {
+		logger.Errorf("Wait.Del: %v", delErr)
+		failed = true
+	}
+	if reportErr != nil && !errors.Is(reportErr, context.Canceled) {
+		logger.Errorf("Wait.Report: %v", reportErr)
+		failed = true
+	}
+	if failed || ctx.Err() != nil {
+		os.Exit(1)
+	}
+
+	if *optCursorFile != "" {
+		// 最後まで消し終えたので再開用のカーソルは不要
+		if err := os.Remove(*optCursorFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Errorf("os.Remove: %v", err)
+		}
+	}
+}
+
+// readKeys キーをsize件ずつのバッチにしてchに流す
+func readKeys(ctx context.Context, cl *datastore.Client, q *datastore.Query, database string, size int, ch chan<- *deleteBatch) error {
+	var seq int64
+	keys := make([]*datastore.Key, 0, size)
+	send := func(it *datastore.Iterator) error {
+		if len(keys) == 0 {
+			return nil
+		}
+		c, err := it.Cursor()
+		if err != nil {
+			return fmt.Errorf("Cursor: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- &deleteBatch{seq: seq, keys: keys, cursor: c}:
+		}
+		seq++
+		keys = make([]*datastore.Key, 0, size)
+		return nil
+	}
+
 	it := cl.Run(ctx, q)
 	for {
 		key, err := it.Next(nil)
 		if errors.Is(err, iterator.Done) {
-			break
+			return send(it)
 		}
 		if err != nil {
-			logger.Errorf("Next: %v", err)
-			return
+			return fmt.Errorf("Next: %w", err)
 		}
 		if *optDisplayKey {
-			fmt.Printf("Key=%v\n", key)
+			fmt.Printf("Key=%s\n", dsutil.FormatKey(key, database))
+		}
+		keys = append(keys, key)
+		if len(keys) == size {
+			if err := send(it); err != nil {
+				return err
+			}
 		}
-		chKey <- key
-	}
-	close(chKey)
-
-	logger.Infof("Waiting for deleting done")
-	if err := egDel.Wait(); err != nil {
-		logger.Errorf("Wait.Del: %v", err)
-		return
-	}
-	close(chReport)
-
-	if err := egReport.Wait(); err != nil {
-		logger.Errorf("Wait.Report: %v", err)
-		return
 	}
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    bool
+		wantErr bool
+	}{
+		{in: "y\n", want: true},
+		{in: " YES \n", want: true},
+		{in: "y", want: true},
+		{in: "n\n"},
+		{in: "\n"},
+		{in: "yy\n"},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := confirm(strings.NewReader(tt.in), "Delete?")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err=%v, wantErr=%t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got=%t, want %t", got, tt.want)
+			}
+		})
+	}
+}
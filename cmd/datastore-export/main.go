@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+	"github.com/tckz/go-gcp-playground/internal/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	myName  = filepath.Base(os.Args[0])
+	logger  *zap.SugaredLogger
+	version string
+)
+
+var (
+	optLogLevel = flag.String("log-level", "info", "info|warn|error")
+	optClient   = dsutil.NewClientOptions(flag.CommandLine)
+	optQuery    = dsutil.NewQueryOptions(flag.CommandLine)
+	optOutput   = flag.String("output", "", "path/to/entities.jsonl [empty = stdout]")
+	optGzip     = flag.Bool("gzip", false, "compress output with gzip, implied when --output ends with .gz")
+	optLogStep  = flag.Int64("log-step", 10000, "")
+)
+
+func init() {
+	godotenv.Load()
+
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (c nopWriteCloser) Close() error {
+	return nil
+}
+
+// gzipWriteCloser gzipを閉じてから下のファイルを閉じる
+type gzipWriteCloser struct {
+	*gzip.Writer
+	under io.Closer
+}
+
+func (c *gzipWriteCloser) Close() error {
+	err := c.Writer.Close()
+	if cerr := c.under.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func openOutput(out string, gz bool) (io.WriteCloser, error) {
+	var w io.WriteCloser = &nopWriteCloser{os.Stdout}
+	if out != "" {
+		fp, err := os.Create(out)
+		if err != nil {
+			return nil, err
+		}
+		w = fp
+	}
+	if gz || strings.HasSuffix(out, ".gz") {
+		return &gzipWriteCloser{Writer: gzip.NewWriter(w), under: w}, nil
+	}
+	return w, nil
+}
+
+func main() {
+	logger.Infof("ver=%s, args=%s", version, os.Args)
+	{
+		now := time.Now()
+		defer func() {
+			logger.Infof("done, dur=%s", time.Since(now))
+		}()
+	}
+
+	q, err := optQuery.Query()
+	if err != nil {
+		logger.Fatalf("*** optQuery.Query: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+		s := <-sig
+		logger.Infof("Received signal: %v", s)
+		cancel()
+	}()
+
+	pjID := optClient.ProjectID()
+	database, err := optClient.DatabaseOf(optQuery.Database())
+	if err != nil {
+		logger.Fatalf("*** %v", err)
+	}
+
+	cl, err := optClient.NewClient(context.Background(), pjID, database)
+	if err != nil {
+		logger.Fatalf("*** optClient.NewClient: %v", err)
+	}
+	defer cl.Close()
+
+	out, err := openOutput(*optOutput, *optGzip)
+	if err != nil {
+		logger.Fatalf("*** openOutput: %v", err)
+	}
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+
+	count, err := export(ctx, cl, q, enc, pjID, database)
+	if err != nil {
+		logger.Errorf("export: %v", err)
+	}
+	logger.Infof("%d entities exported", count)
+
+	if ferr := bw.Flush(); ferr != nil {
+		logger.Errorf("Flush: %v", ferr)
+		err = ferr
+	}
+	if cerr := out.Close(); cerr != nil {
+		logger.Errorf("Close: %v", cerr)
+		err = cerr
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func export(ctx context.Context, cl *datastore.Client, q *datastore.Query, enc *json.Encoder, pjID, database string) (int64, error) {
+	var count int64
+	it := cl.Run(ctx, q)
+	for {
+		var props datastore.PropertyList
+		key, err := it.Next(&props)
+		if errors.Is(err, iterator.Done) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		ent, err := dsutil.NewEntity(key, props)
+		if err != nil {
+			return count, err
+		}
+		// importの際にどこから持ってきたものか分かるように残しておく
+		if ent.Key.PartitionID == nil {
+			ent.Key.PartitionID = &dsutil.PartitionID{}
+		}
+		ent.Key.PartitionID.ProjectID = pjID
+		ent.Key.PartitionID.DatabaseID = database
+
+		if err := enc.Encode(ent); err != nil {
+			return count, err
+		}
+		count++
+		if *optLogStep > 0 && count%*optLogStep == 0 {
+			logger.Infof("exported=%d", count)
+		}
+	}
+}
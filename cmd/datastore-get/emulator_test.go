@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+	"github.com/tckz/go-gcp-playground/internal/dsutil/dstest"
+)
+
+func TestMain(m *testing.M) {
+	dstest.Main(m, main)
+}
+
+func TestGetEmulator(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range dstest.Cases() {
+		t.Run(c.Name, func(t *testing.T) {
+			cl := dstest.Client(t, c.Database)
+			kind := dstest.Unique("Item")
+			key := c.Key(kind, "a", nil)
+			if _, err := cl.Put(ctx, key, &datastore.PropertyList{{Name: "Name", Value: "x"}}); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			// キー式のdb:とns:で指定しても、--databaseと--nsで指定しても同じ
+			for _, args := range [][]string{
+				{"--key", c.KeyString(key)},
+				append(c.Args(), "--kind", kind, "--name", "a"),
+			} {
+				out, err := dstest.Run(t, args...)
+				if err != nil {
+					t.Fatalf("Run(%v): %v", args, err)
+				}
+				var ent dsutil.Entity
+				if err := json.Unmarshal([]byte(out), &ent); err != nil {
+					t.Fatalf("json.Unmarshal: %v, %s", err, out)
+				}
+
+				var pid dsutil.PartitionID
+				if ent.Key.PartitionID != nil {
+					pid = *ent.Key.PartitionID
+				}
+				if pid.DatabaseID != c.Database || pid.NamespaceID != c.Namespace {
+					t.Errorf("partitionId=%+v, want database=%s, namespace=%s", pid, c.Database, c.Namespace)
+				}
+				if v := ent.Properties["Name"]; v == nil || v.StringValue == nil || *v.StringValue != "x" {
+					t.Errorf("properties=%s", out)
+				}
+			}
+		})
+	}
+}
+
+func TestGetEmulatorNotFound(t *testing.T) {
+	dstest.SkipWithoutEmulator(t)
+	out, _ := dstest.Run(t, "--key", dstest.Unique("Item")+"/'missing'")
+	if out != "" {
+		t.Errorf("out=%s, want nothing", out)
+	}
+}
@@ -2,14 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
-	"fmt"
 	"os"
 	"path/filepath"
-	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
 	"github.com/tckz/go-gcp-playground/internal/log"
 	"go.uber.org/zap"
 )
@@ -22,46 +22,70 @@ var (
 
 var (
 	optLogLevel  = flag.String("log-level", "info", "info|warn|error")
+	optClient    = dsutil.NewClientOptions(flag.CommandLine)
 	optNameSpace = flag.String("ns", "", "namespace")
 	optName      = flag.String("name", "", "named key")
 	optKind      = flag.String("kind", "mykind", "namespace")
+	optPretty    = flag.Bool("pretty", false, "indent JSON output")
+	optKey       = &dsutil.KeyFlag{}
 )
 
 func init() {
 	godotenv.Load()
 
-	flag.Parse()
-
-	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
-}
-
-type MyKind struct {
-	Name string
-	Time time.Time
+	flag.Var(optKey, "key", "[db:DATABASE/][ns:NAMESPACE/]Kind/ident/... or encoded key, instead of --kind and --name")
 }
 
 func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
 	logger.Infof("ver=%s, args=%s", version, os.Args)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	pjID := os.Getenv("PROJECT_ID")
+	ref := optKey.KeyRef
+	if ref.Key == nil {
+		key := datastore.NameKey(*optKind, *optName, nil)
+		key.Namespace = *optNameSpace
+		ref.Key = key
+	}
+	if ref.Key.Incomplete() {
+		logger.Fatalf("*** key must be complete: %s", ref)
+	}
+	key := ref.Key
+	database, err := optClient.DatabaseOf(ref.Database)
+	if err != nil {
+		logger.Fatalf("*** %v", err)
+	}
 
-	cl, err := datastore.NewClient(context.Background(), pjID)
+	cl, err := optClient.NewClient(context.Background(), "", database)
 	if err != nil {
-		logger.Fatalf("*** datastore.NewClient: %v", err)
+		logger.Fatalf("*** optClient.NewClient: %v", err)
 	}
 	defer cl.Close()
 
-	key := datastore.NameKey(*optKind, *optName, nil)
-	key.Namespace = *optNameSpace
-
-	var rec MyKind
-	if err := cl.Get(ctx, key, &rec); err != nil {
+	// kindごとに構造体を用意しなくてよいようにPropertyListで受ける
+	var props datastore.PropertyList
+	if err := cl.Get(ctx, key, &props); err != nil {
 		logger.Errorf("Get: %v", err)
 		return
 	}
 
-	fmt.Fprintf(os.Stdout, "%+v\n", rec)
+	ent, err := dsutil.NewEntity(key, props)
+	if err != nil {
+		logger.Errorf("dsutil.NewEntity: %v", err)
+		return
+	}
+	// デフォルトのnamespaceだとPartitionIDがnilなのでNewKeyRefに任せる
+	ent.Key = dsutil.NewKeyRef(dsutil.KeyRef{Database: database, Key: key})
+
+	enc := json.NewEncoder(os.Stdout)
+	if *optPretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(ent); err != nil {
+		logger.Errorf("Encode: %v", err)
+	}
 }
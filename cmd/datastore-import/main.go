@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+	"github.com/tckz/go-gcp-playground/internal/log"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	myName  = filepath.Base(os.Args[0])
+	logger  *zap.SugaredLogger
+	version string
+)
+
+var (
+	optLogLevel    = flag.String("log-level", "info", "info|warn|error")
+	optClient      = dsutil.NewClientOptions(flag.CommandLine)
+	optProject     = flag.String("project", "", "project to put entities into [empty = PROJECT_ID]")
+	optNameSpace   = flag.String("ns", "", "namespace to put all entities into, takes precedence over --ns-map [empty = as is]")
+	optNSMap       = &nsMapFlag{}
+	optWorkers     = flag.Int("workers", 8, "Number of concurrent PutMulti")
+	optBatchSize   = flag.Int("batch-size", 500, "Number of entities per PutMulti, up to 500")
+	optMaxLineSize = flag.Int("max-line-size", 10*1024*1024, "Max bytes of an input line")
+	optTicker      = flag.Duration("ticker", 10*time.Second, "Interval of progress logs")
+)
+
+func init() {
+	godotenv.Load()
+
+	flag.Var(optNSMap, "ns-map", "SRC=DST, put entities of namespace SRC into DST, empty SRC means the default namespace, can be specified multiple times")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [file ...]\n  reads stdin when no file or '-' is specified, gzip is detected automatically\n", myName)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+}
+
+type nsMapFlag struct {
+	values []string
+	m      map[string]string
+}
+
+func (f *nsMapFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *nsMapFlag) Set(s string) error {
+	src, dst, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("must be SRC=DST: %s", s)
+	}
+	if f.m == nil {
+		f.m = map[string]string{}
+	}
+	f.values = append(f.values, s)
+	f.m[src] = dst
+	return nil
+}
+
+func mapNamespace(ns string) string {
+	if *optNameSpace != "" {
+		return *optNameSpace
+	}
+	if v, ok := optNSMap.m[ns]; ok {
+		return v
+	}
+	return ns
+}
+
+// remapKey 祖先も含めてnamespaceを付け替える
+func remapKey(k *datastore.Key) {
+	for e := k; e != nil; e = e.Parent {
+		e.Namespace = mapNamespace(e.Namespace)
+	}
+}
+
+// remapValue プロパティに含まれるキーも同じように付け替える
+func remapValue(v interface{}) {
+	switch v := v.(type) {
+	case *datastore.Key:
+		remapKey(v)
+	case *datastore.Entity:
+		remapKey(v.Key)
+		for _, p := range v.Properties {
+			remapValue(p.Value)
+		}
+	case []interface{}:
+		for _, e := range v {
+			remapValue(e)
+		}
+	}
+}
+
+type entity struct {
+	key   *datastore.Key
+	props datastore.PropertyList
+}
+
+func openInput(fn string) (io.ReadCloser, error) {
+	var rc io.ReadCloser = io.NopCloser(os.Stdin)
+	if fn != "-" {
+		fp, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		rc = fp
+	}
+
+	br := bufio.NewReader(rc)
+	// gzipのマジックナンバーで判定する
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("gzip.NewReader: %w", err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gr, rc}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, rc}, nil
+}
+
+func readEntities(ctx context.Context, files []string, ch chan<- *entity) error {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	for _, fn := range files {
+		if err := func() error {
+			r, err := openInput(fn)
+			if err != nil {
+				return fmt.Errorf("openInput: %w", err)
+			}
+			defer r.Close()
+
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(make([]byte, 0, 64*1024), *optMaxLineSize)
+			line := 0
+			for scanner.Scan() {
+				line++
+				b := scanner.Bytes()
+				if len(b) == 0 {
+					continue
+				}
+
+				var ent dsutil.Entity
+				if err := json.Unmarshal(b, &ent); err != nil {
+					return fmt.Errorf("%s:%d: json.Unmarshal: %w", fn, line, err)
+				}
+				key, err := ent.Key.DatastoreKey()
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", fn, line, err)
+				}
+				if key == nil || key.Incomplete() {
+					return fmt.Errorf("%s:%d: entity must have a complete key", fn, line)
+				}
+				props, err := ent.PropertyList()
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", fn, line, err)
+				}
+
+				remapKey(key)
+				for _, p := range props {
+					remapValue(p.Value)
+				}
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case ch <- &entity{key: key, props: props}:
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				return fmt.Errorf("%s:%d: scanner.Err: %w", fn, line+1, err)
+			}
+			return nil
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	logger.Infof("ver=%s, args=%s", version, os.Args)
+	{
+		now := time.Now()
+		defer func() {
+			logger.Infof("done, dur=%s", time.Since(now))
+		}()
+	}
+
+	const MaxPutItem = 500
+	if *optBatchSize <= 0 || *optBatchSize > MaxPutItem {
+		logger.Fatalf("*** --batch-size must be in [1, %d].", MaxPutItem)
+	}
+	if *optWorkers <= 0 {
+		logger.Fatalf("*** --workers must be > 0.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+		s := <-sig
+		logger.Infof("Received signal: %v", s)
+		cancel()
+	}()
+
+	cl, err := optClient.NewClient(context.Background(), *optProject, *optClient.Database)
+	if err != nil {
+		logger.Fatalf("*** optClient.NewClient: %v", err)
+	}
+	defer cl.Close()
+
+	var sum int64
+	go func() {
+		t := time.NewTicker(*optTicker)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				logger.Infof("tick:put=%d", atomic.LoadInt64(&sum))
+			}
+		}
+	}()
+
+	chEnt := make(chan *entity, *optBatchSize)
+
+	egPut, ctxPut := errgroup.WithContext(ctx)
+	for i := 0; i < *optWorkers; i++ {
+		egPut.Go(func() error {
+			keys := make([]*datastore.Key, 0, *optBatchSize)
+			props := make([]datastore.PropertyList, 0, *optBatchSize)
+			flush := func() error {
+				if len(keys) == 0 {
+					return nil
+				}
+				l := len(keys)
+				now := time.Now()
+				if _, err := cl.PutMulti(ctxPut, keys, props); err != nil {
+					return err
+				}
+				logger.Infof("Put %d entities, dur=%s", l, time.Since(now))
+				keys = keys[:0]
+				props = props[:0]
+				atomic.AddInt64(&sum, int64(l))
+				return nil
+			}
+
+			for {
+				select {
+				case <-ctxPut.Done():
+					return ctxPut.Err()
+				case e, ok := <-chEnt:
+					if !ok {
+						return flush()
+					}
+					keys = append(keys, e.key)
+					props = append(props, e.props)
+					if len(keys) == *optBatchSize {
+						if err := flush(); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		})
+	}
+
+	readErr := readEntities(ctxPut, flag.Args(), chEnt)
+	close(chEnt)
+
+	putErr := egPut.Wait()
+	logger.Infof("%d entities put", sum)
+
+	failed := false
+	if readErr != nil && !errors.Is(readErr, context.Canceled) {
+		logger.Errorf("readEntities: %v", readErr)
+		failed = true
+	}
+	if putErr != nil && !errors.Is(putErr, context.Canceled) {
+		logger.Errorf("Wait.Put: %v", putErr)
+		failed = true
+	}
+	if failed || ctx.Err() != nil {
+		os.Exit(1)
+	}
+}
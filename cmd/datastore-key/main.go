@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+	"github.com/tckz/go-gcp-playground/internal/log"
+	"go.uber.org/zap"
+)
+
+var (
+	myName  = filepath.Base(os.Args[0])
+	logger  *zap.SugaredLogger
+	version string
+)
+
+var (
+	optLogLevel = flag.String("log-level", "warn", "info|warn|error")
+	optTo       = flag.String("to", "all", "expr|encoded|json|all, all prints {expr, encoded, key} as JSON")
+	// エンコード済みのキーはデータベースを持たないので必要なら補う
+	optDatabase = flag.String("database", "", "database of keys without db: [empty = as is]")
+)
+
+func init() {
+	godotenv.Load()
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [key ...]\n  reads keys from stdin line by line when no key is specified\n", myName)
+		flag.PrintDefaults()
+	}
+}
+
+type keyForms struct {
+	Expr    string      `json:"expr"`
+	Encoded string      `json:"encoded"`
+	Key     *dsutil.Key `json:"key"`
+}
+
+// convert toは --to の値、databaseはdb:のないキーに補うデータベース
+func convert(w io.Writer, to, database, s string) error {
+	ref, err := dsutil.ParseKey(s)
+	if err != nil {
+		return err
+	}
+	if ref.Database == "" {
+		ref.Database = database
+	}
+
+	enc := json.NewEncoder(w)
+	switch to {
+	case "expr":
+		_, err := fmt.Fprintln(w, ref)
+		return err
+	case "encoded":
+		_, err := fmt.Fprintln(w, ref.Key.Encode())
+		return err
+	case "json":
+		return enc.Encode(dsutil.NewKeyRef(ref))
+	default:
+		return enc.Encode(&keyForms{
+			Expr:    ref.String(),
+			Encoded: ref.Key.Encode(),
+			Key:     dsutil.NewKeyRef(ref),
+		})
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+
+	switch *optTo {
+	case "expr", "encoded", "json", "all":
+	default:
+		logger.Fatalf("*** --to must be expr, encoded, json or all.")
+	}
+
+	failed := 0
+	handle := func(s string) {
+		if err := convert(os.Stdout, *optTo, *optDatabase, s); err != nil {
+			logger.Errorf("*** %s: %v", s, err)
+			failed++
+		}
+	}
+
+	if flag.NArg() > 0 {
+		for _, s := range flag.Args() {
+			handle(s)
+		}
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if s := scanner.Text(); s != "" {
+				handle(s)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			logger.Fatalf("*** scanner.Err: %v", err)
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestConvert(t *testing.T) {
+	key := datastore.IDKey("C", 1, datastore.NameKey("P", "p", nil))
+	key.Namespace = "ns1"
+	key.Parent.Namespace = "ns1"
+	encoded := key.Encode()
+
+	tests := []struct {
+		name     string
+		to       string
+		database string
+		in       string
+		want     string
+		wantErr  string
+	}{
+		{name: "expr", to: "expr", in: encoded, want: "ns:ns1/P/p/C/1\n"},
+		{name: "expr with database", to: "expr", database: "db1", in: encoded, want: "db:db1/ns:ns1/P/p/C/1\n"},
+		{name: "expr keeps db:", to: "expr", database: "db2", in: "db:db1/K/'1'", want: "db:db1/K/'1'\n"},
+		{name: "encoded", to: "encoded", in: "ns:ns1/P/p/C/1", want: encoded + "\n"},
+		// エンコード済みのキーはデータベースを持たない
+		{name: "encoded drops database", to: "encoded", in: "db:db1/ns:ns1/P/p/C/1", want: encoded + "\n"},
+		{
+			name: "json",
+			to:   "json",
+			in:   "db:db1/K/a%2Fb",
+			want: `{"partitionId":{"databaseId":"db1"},"path":[{"kind":"K","name":"a/b"}]}` + "\n",
+		},
+		{
+			name:     "json incomplete",
+			to:       "json",
+			database: "db1",
+			in:       "K",
+			want:     `{"partitionId":{"databaseId":"db1"},"path":[{"kind":"K"}]}` + "\n",
+		},
+		{
+			name: "all",
+			to:   "all",
+			in:   encoded,
+			want: `{"expr":"ns:ns1/P/p/C/1","encoded":"` + encoded + `","key":{"partitionId":{"namespaceId":"ns1"},"path":[{"kind":"P","name":"p"},{"kind":"C","id":"1"}]}}` + "\n",
+		},
+		{name: "malformed", to: "expr", in: "/1", wantErr: "empty kind"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := convert(&buf, tt.to, tt.database, tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got=%s, want %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/dsutil/dstest"
+)
+
+func TestMain(m *testing.M) {
+	dstest.Main(m, main)
+}
+
+func TestSchemaEmulator(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range dstest.Cases() {
+		t.Run(c.Name, func(t *testing.T) {
+			cl := dstest.Client(t, c.Database)
+			kind := dstest.Unique("Item")
+			keys := []*datastore.Key{c.Key(kind, "a", nil), c.Key(kind, "b", nil)}
+			props := []datastore.PropertyList{
+				{
+					{Name: "Name", Value: "a"},
+					{Name: "Address", Value: &datastore.Entity{Properties: []datastore.Property{{Name: "City", Value: "tokyo"}}}},
+					{Name: "Tags", Value: []interface{}{"x", "y"}},
+				},
+				{
+					{Name: "Name", Value: int64(1)},
+					{Name: "Items", Value: []interface{}{
+						&datastore.Entity{Properties: []datastore.Property{{Name: "Sku", Value: "s1"}}},
+					}},
+				},
+			}
+			if _, err := cl.PutMulti(ctx, keys, props); err != nil {
+				t.Fatalf("PutMulti: %v", err)
+			}
+
+			args := append(c.Args(), "--kind", kind, "--sample", "10", "--count", "schema")
+			out, err := dstest.Run(t, args...)
+			if err != nil {
+				t.Fatalf("Run(%v): %v", args, err)
+			}
+			var got kindSchema
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatalf("json.Unmarshal: %v, %s", err, out)
+			}
+			if got.Namespace != c.Namespace || got.Kind != kind || got.Count == nil || *got.Count != 2 {
+				t.Errorf("namespace=%s, kind=%s, count=%v", got.Namespace, got.Kind, got.Count)
+			}
+			types := map[string][]string{}
+			for k, v := range got.Properties {
+				if len(v.Types) > 0 {
+					types[k] = v.Types
+				}
+			}
+			want := map[string][]string{
+				"Name":         {"integer", "string"},
+				"Address":      {"entity"},
+				"Address.City": {"string"},
+				"Tags":         {"array"},
+				"Tags[]":       {"string"},
+				"Items":        {"array"},
+				"Items[]":      {"entity"},
+				"Items[].Sku":  {"string"},
+			}
+			if !reflect.DeepEqual(types, want) {
+				t.Errorf("types=%v, want %v", types, want)
+			}
+
+			out, err = dstest.Run(t, append(c.Args(), "kinds")...)
+			if err != nil {
+				t.Fatalf("Run kinds: %v", err)
+			}
+			if !strings.Contains(out, `"kind":"`+kind+`"`) {
+				t.Errorf("kinds does not contain %s: %s", kind, out)
+			}
+
+			if _, err := dstest.Run(t, append(c.Args(), "nosuch")...); err == nil {
+				t.Errorf("unknown command must fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+	"github.com/tckz/go-gcp-playground/internal/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	myName  = filepath.Base(os.Args[0])
+	logger  *zap.SugaredLogger
+	version string
+)
+
+var (
+	optLogLevel      = flag.String("log-level", "info", "info|warn|error")
+	optClient        = dsutil.NewClientOptions(flag.CommandLine)
+	optNameSpace     = flag.String("ns", "", "namespace")
+	optAllNameSpaces = flag.Bool("all-ns", false, "list over all namespaces instead of --ns")
+	optKind          = flag.String("kind", "", "kind for properties and schema [empty = all kinds]")
+	optCount         = flag.Bool("count", false, "add number of entities, by an aggregation query or counting keys on the emulator")
+	optSample        = flag.Int("sample", 0, "Number of entities per kind to infer schema from, includes unindexed properties [0 = metadata only]")
+	optPretty        = flag.Bool("pretty", false, "indent JSON output")
+)
+
+func init() {
+	godotenv.Load()
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] namespaces|kinds|properties|schema\n", myName)
+		flag.PrintDefaults()
+	}
+}
+
+type namespaceInfo struct {
+	Namespace string `json:"namespace"`
+	Kinds     *int   `json:"kinds,omitempty"`
+}
+
+type kindInfo struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Count     *int64 `json:"count,omitempty"`
+}
+
+type propertyInfo struct {
+	Namespace       string   `json:"namespace"`
+	Kind            string   `json:"kind"`
+	Property        string   `json:"property"`
+	Representations []string `json:"representations"`
+}
+
+// propertySchema representationsは__property__のもの、typesは読んだエンティティから見つかった型
+type propertySchema struct {
+	Representations []string `json:"representations,omitempty"`
+	Types           []string `json:"types,omitempty"`
+}
+
+type kindSchema struct {
+	Namespace  string                     `json:"namespace"`
+	Kind       string                     `json:"kind"`
+	Count      *int64                     `json:"count,omitempty"`
+	Properties map[string]*propertySchema `json:"properties"`
+}
+
+// propertyMeta __property__のエンティティ
+type propertyMeta struct {
+	Representations []string `datastore:"property_representation"`
+}
+
+func listNamespaces(ctx context.Context, cl *datastore.Client) ([]string, error) {
+	keys, err := cl.GetAll(ctx, datastore.NewQuery("__namespace__").KeysOnly(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("GetAll: %w", err)
+	}
+	ret := make([]string, 0, len(keys))
+	for _, k := range keys {
+		// デフォルトのnamespaceはID=1のキーになるのでNameは空
+		ret = append(ret, k.Name)
+	}
+	return ret, nil
+}
+
+// listKinds __Stat_などのシステムのkindは除く
+func listKinds(ctx context.Context, cl *datastore.Client, ns string) ([]string, error) {
+	keys, err := cl.GetAll(ctx, datastore.NewQuery("__kind__").Namespace(ns).KeysOnly(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("GetAll: %w", err)
+	}
+	ret := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if strings.HasPrefix(k.Name, "__") {
+			continue
+		}
+		ret = append(ret, k.Name)
+	}
+	return ret, nil
+}
+
+// listProperties kindが空なら全kindのプロパティを返す。インデックスされているプロパティしか現れない
+func listProperties(ctx context.Context, cl *datastore.Client, ns, kind string) ([]propertyInfo, error) {
+	q := datastore.NewQuery("__property__").Namespace(ns)
+	if kind != "" {
+		ancestor := datastore.NameKey("__kind__", kind, nil)
+		ancestor.Namespace = ns
+		q = q.Ancestor(ancestor)
+	}
+
+	var metas []propertyMeta
+	keys, err := cl.GetAll(ctx, q, &metas)
+	if err != nil {
+		return nil, fmt.Errorf("GetAll: %w", err)
+	}
+	ret := make([]propertyInfo, 0, len(keys))
+	for i, k := range keys {
+		if k.Parent == nil || strings.HasPrefix(k.Parent.Name, "__") {
+			continue
+		}
+		ret = append(ret, propertyInfo{
+			Namespace:       ns,
+			Kind:            k.Parent.Name,
+			Property:        k.Name,
+			Representations: metas[i].Representations,
+		})
+	}
+	return ret, nil
+}
+
+// valueType dsutil.Valueのフィールド名に合わせた型名
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case time.Time:
+		return "timestamp"
+	case []byte:
+		return "blob"
+	case *datastore.Key:
+		return "key"
+	case datastore.GeoPoint:
+		return "geoPoint"
+	case *datastore.Entity:
+		return "entity"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// addTypes 入れ子のエンティティのプロパティはa.bの形の名前にする
+func addTypes(types map[string]map[string]bool, prefix string, props []datastore.Property) {
+	add := func(name string, v interface{}) {
+		if types[name] == nil {
+			types[name] = map[string]bool{}
+		}
+		types[name][valueType(v)] = true
+	}
+	for _, p := range props {
+		name := prefix + p.Name
+		add(name, p.Value)
+		switch v := p.Value.(type) {
+		case *datastore.Entity:
+			addTypes(types, name+".", v.Properties)
+		case []interface{}:
+			for _, e := range v {
+				add(name+"[]", e)
+				if ent, ok := e.(*datastore.Entity); ok {
+					addTypes(types, name+"[].", ent.Properties)
+				}
+			}
+		}
+	}
+}
+
+func inferSchema(ctx context.Context, cl *datastore.Client, ns, kind string) (*kindSchema, error) {
+	ret := &kindSchema{Namespace: ns, Kind: kind, Properties: map[string]*propertySchema{}}
+	get := func(name string) *propertySchema {
+		ps, ok := ret.Properties[name]
+		if !ok {
+			ps = &propertySchema{}
+			ret.Properties[name] = ps
+		}
+		return ps
+	}
+
+	props, err := listProperties(ctx, cl, ns, kind)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range props {
+		get(p.Property).Representations = p.Representations
+	}
+
+	if *optSample > 0 {
+		types := map[string]map[string]bool{}
+		it := cl.Run(ctx, datastore.NewQuery(kind).Namespace(ns).Limit(*optSample))
+		for {
+			var pl datastore.PropertyList
+			_, err := it.Next(&pl)
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Next: %w", err)
+			}
+			addTypes(types, "", pl)
+		}
+		for name, ts := range types {
+			l := make([]string, 0, len(ts))
+			for t := range ts {
+				l = append(l, t)
+			}
+			sort.Strings(l)
+			get(name).Types = l
+		}
+	}
+	return ret, nil
+}
+
+func count(ctx context.Context, cl *datastore.Client, ns, kind string) (*int64, error) {
+	if !*optCount {
+		return nil, nil
+	}
+	n, err := dsutil.Count(ctx, cl, datastore.NewQuery(kind).Namespace(ns))
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+	logger.Infof("ver=%s, args=%s", version, os.Args)
+	defer logger.Infof("done")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		logger.Fatalf("*** command must be specified.")
+	}
+	command := flag.Arg(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl, err := optClient.NewClient(context.Background(), "", *optClient.Database)
+	if err != nil {
+		logger.Fatalf("*** optClient.NewClient: %v", err)
+	}
+	defer cl.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	if *optPretty {
+		enc.SetIndent("", "  ")
+	}
+
+	namespaces := []string{*optNameSpace}
+	if command == "namespaces" || *optAllNameSpaces {
+		namespaces, err = listNamespaces(ctx, cl)
+		if err != nil {
+			logger.Fatalf("*** listNamespaces: %v", err)
+		}
+	}
+
+	if err := run(ctx, cl, enc, command, namespaces); err != nil {
+		logger.Fatalf("*** %s: %v", command, err)
+	}
+}
+
+func run(ctx context.Context, cl *datastore.Client, enc *json.Encoder, command string, namespaces []string) error {
+	kindsOf := func(ns string) ([]string, error) {
+		if *optKind != "" {
+			return []string{*optKind}, nil
+		}
+		return listKinds(ctx, cl, ns)
+	}
+
+	for _, ns := range namespaces {
+		switch command {
+		case "namespaces":
+			info := namespaceInfo{Namespace: ns}
+			if *optCount {
+				kinds, err := listKinds(ctx, cl, ns)
+				if err != nil {
+					return err
+				}
+				n := len(kinds)
+				info.Kinds = &n
+			}
+			if err := enc.Encode(info); err != nil {
+				return err
+			}
+		case "kinds":
+			kinds, err := listKinds(ctx, cl, ns)
+			if err != nil {
+				return err
+			}
+			for _, k := range kinds {
+				n, err := count(ctx, cl, ns, k)
+				if err != nil {
+					return err
+				}
+				if err := enc.Encode(kindInfo{Namespace: ns, Kind: k, Count: n}); err != nil {
+					return err
+				}
+			}
+		case "properties":
+			props, err := listProperties(ctx, cl, ns, *optKind)
+			if err != nil {
+				return err
+			}
+			for _, p := range props {
+				if err := enc.Encode(p); err != nil {
+					return err
+				}
+			}
+		case "schema":
+			kinds, err := kindsOf(ns)
+			if err != nil {
+				return err
+			}
+			for _, k := range kinds {
+				s, err := inferSchema(ctx, cl, ns, k)
+				if err != nil {
+					return err
+				}
+				if s.Count, err = count(ctx, cl, ns, k); err != nil {
+					return err
+				}
+				if err := enc.Encode(s); err != nil {
+					return err
+				}
+			}
+		default:
+			flag.Usage()
+			return fmt.Errorf("unknown command: %s", command)
+		}
+	}
+	return nil
+}
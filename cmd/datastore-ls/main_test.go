@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestValueType(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, "null"},
+		{true, "boolean"},
+		{int64(1), "integer"},
+		{1.5, "double"},
+		{"s", "string"},
+		{time.Now(), "timestamp"},
+		{[]byte{1}, "blob"},
+		{datastore.NameKey("K", "k", nil), "key"},
+		{datastore.GeoPoint{Lat: 1, Lng: 2}, "geoPoint"},
+		{&datastore.Entity{}, "entity"},
+		{[]interface{}{int64(1)}, "array"},
+		{int32(1), "int32"},
+	}
+	for _, tt := range tests {
+		if got := valueType(tt.v); got != tt.want {
+			t.Errorf("valueType(%#v)=%s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestAddTypes(t *testing.T) {
+	inner := &datastore.Entity{Properties: []datastore.Property{
+		{Name: "city", Value: "tokyo"},
+		{Name: "geo", Value: &datastore.Entity{Properties: []datastore.Property{{Name: "lat", Value: 35.6}}}},
+	}}
+	types := map[string]map[string]bool{}
+	// 2つのエンティティで型の違うものは両方残る
+	addTypes(types, "", []datastore.Property{
+		{Name: "name", Value: "a"},
+		{Name: "address", Value: inner},
+		{Name: "tags", Value: []interface{}{"x", int64(1)}},
+		{Name: "items", Value: []interface{}{
+			&datastore.Entity{Properties: []datastore.Property{{Name: "sku", Value: "s1"}}},
+			&datastore.Entity{Properties: []datastore.Property{{Name: "sku", Value: int64(2)}, {Name: "qty", Value: int64(3)}}},
+		}},
+		{Name: "empty", Value: []interface{}{}},
+	})
+	addTypes(types, "", []datastore.Property{
+		{Name: "name", Value: nil},
+		{Name: "address", Value: "unknown"},
+	})
+
+	set := func(v ...string) map[string]bool {
+		ret := map[string]bool{}
+		for _, e := range v {
+			ret[e] = true
+		}
+		return ret
+	}
+	want := map[string]map[string]bool{
+		"name":            set("string", "null"),
+		"address":         set("entity", "string"),
+		"address.city":    set("string"),
+		"address.geo":     set("entity"),
+		"address.geo.lat": set("double"),
+		"tags":            set("array"),
+		"tags[]":          set("string", "integer"),
+		"items":           set("array"),
+		"items[]":         set("entity"),
+		"items[].sku":     set("string", "integer"),
+		"items[].qty":     set("integer"),
+		"empty":           set("array"),
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("got=%v\nwant %v", types, want)
+	}
+}
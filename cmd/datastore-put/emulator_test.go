@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/dsutil/dstest"
+)
+
+func TestMain(m *testing.M) {
+	dstest.Main(m, main)
+}
+
+func TestPutEmulator(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range dstest.Cases() {
+		t.Run(c.Name, func(t *testing.T) {
+			cl := dstest.Client(t, c.Database)
+			kind := dstest.Unique("Item")
+
+			a := c.Key(kind, "a", nil)
+			b := c.Key(kind, "b", nil)
+			// 子は祖先のnamespaceに置かれる
+			child := c.Key("Child", "c", a)
+			for _, args := range [][]string{
+				{"--key", c.KeyString(a)},
+				append(c.Args(), "--kind", kind, "--name", "b"),
+				{"--ancestor", c.KeyString(a), "--kind", "Child", "--name", "c"},
+			} {
+				if _, err := dstest.Run(t, args...); err != nil {
+					t.Fatalf("Run(%v): %v", args, err)
+				}
+			}
+
+			for _, e := range []struct {
+				key  *datastore.Key
+				want string
+			}{
+				{a, "my name is a"},
+				{b, "my name is b"},
+				{child, "my name is c"},
+			} {
+				var rec MyKind
+				if err := cl.Get(ctx, e.key, &rec); err != nil {
+					t.Fatalf("Get(%s): %v", c.KeyString(e.key), err)
+				}
+				if rec.Name != e.want {
+					t.Errorf("Name=%s, want %s", rec.Name, e.want)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"time"
@@ -10,6 +11,8 @@ import (
 	"cloud.google.com/go/datastore"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/samber/lo"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
 	"github.com/tckz/go-gcp-playground/internal/log"
 	"go.uber.org/zap"
 )
@@ -21,21 +24,20 @@ var (
 )
 
 var (
-	optLogLevel          = flag.String("log-level", "info", "info|warn|error")
-	optAncestorNameSpace = flag.String("ancestor-ns", "", "ancestor namespace")
-	optAncestorKind      = flag.String("ancestor-kind", "", "ancestor kind")
-	optAncestorName      = flag.String("ancestor-name", "", "ancestor named key")
-	optNameSpace         = flag.String("ns", "", "namespace")
-	optName              = flag.String("name", "", "named key")
-	optKind              = flag.String("kind", "mykind", "namespace")
+	optLogLevel  = flag.String("log-level", "info", "info|warn|error")
+	optClient    = dsutil.NewClientOptions(flag.CommandLine)
+	optAncestor  = &dsutil.KeyFlag{}
+	optNameSpace = flag.String("ns", "", "namespace")
+	optName      = flag.String("name", "", "named key")
+	optKind      = flag.String("kind", "mykind", "namespace")
+	optKey       = &dsutil.KeyFlag{}
 )
 
 func init() {
 	godotenv.Load()
 
-	flag.Parse()
-
-	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+	flag.Var(optAncestor, "ancestor", "[db:DATABASE/][ns:NAMESPACE/]Kind/ident/... or encoded key of ancestor")
+	flag.Var(optKey, "key", "[db:DATABASE/][ns:NAMESPACE/]Kind/ident/... or encoded key, instead of --kind, --name and --ancestor, ident of the last kind can be omitted to allocate ID")
 }
 
 type MyKind struct {
@@ -43,38 +45,66 @@ type MyKind struct {
 	Time time.Time
 }
 
+// newKeyRef 子は祖先と同じnamespaceにしか置けないので、nsを指定するなら祖先のnamespaceと一致しなければエラー
+func newKeyRef(kind, name, ns string, ancestor dsutil.KeyRef) (dsutil.KeyRef, error) {
+	if ancestor.Key == nil {
+		key := datastore.NameKey(kind, name, nil)
+		key.Namespace = ns
+		return dsutil.KeyRef{Database: ancestor.Database, Key: key}, nil
+	}
+
+	if ancestor.Key.Incomplete() {
+		return dsutil.KeyRef{}, fmt.Errorf("ancestor must be complete: %s", ancestor)
+	}
+	if ns != "" && ns != ancestor.Key.Namespace {
+		return dsutil.KeyRef{}, fmt.Errorf("namespace %q differs from the ancestor's one %q", ns, ancestor.Key.Namespace)
+	}
+	key := datastore.NameKey(kind, name, ancestor.Key)
+	key.Namespace = ancestor.Key.Namespace
+	return dsutil.KeyRef{Database: ancestor.Database, Key: key}, nil
+}
+
 func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
 	logger.Infof("ver=%s, args=%s", version, os.Args)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	pjID := os.Getenv("PROJECT_ID")
-
-	cl, err := datastore.NewClient(context.Background(), pjID)
-	if err != nil {
-		logger.Fatalf("*** datastore.NewClient: %v", err)
+	ref := optKey.KeyRef
+	if ref.Key == nil {
+		name := *optName
+		if name == "" {
+			name = uuid.New().String()
+		}
+		var err error
+		ref, err = newKeyRef(*optKind, name, *optNameSpace, optAncestor.KeyRef)
+		if err != nil {
+			logger.Fatalf("*** --ancestor: %v", err)
+		}
 	}
-	defer cl.Close()
 
-	var parentKey *datastore.Key
-	if *optAncestorKind != "" {
-		parentKey = datastore.NameKey(*optAncestorKind, *optAncestorName, nil)
-		parentKey.Namespace = *optAncestorNameSpace
+	database, err := optClient.DatabaseOf(ref.Database)
+	if err != nil {
+		logger.Fatalf("*** %v", err)
 	}
+	ref.Database = database
 
-	name := *optName
-	if name == "" {
-		name = uuid.New().String()
+	cl, err := optClient.NewClient(context.Background(), "", ref.Database)
+	if err != nil {
+		logger.Fatalf("*** optClient.NewClient: %v", err)
 	}
-	key := datastore.NameKey(*optKind, name, parentKey)
-	key.Namespace = *optNameSpace
+	defer cl.Close()
 
 	rec := MyKind{
-		Name: "my name is " + name,
+		Name: "my name is " + lo.CoalesceOrEmpty(ref.Key.Name, ref.String()),
 		Time: time.Now().UTC(),
 	}
-	if _, err := cl.Put(ctx, key, &rec); err != nil {
-		logger.Errorf("Put: %v", err)
+	key, err := cl.Put(ctx, ref.Key, &rec)
+	if err != nil {
+		logger.Fatalf("*** Put: %v", err)
 	}
+	logger.Infof("put: %s", dsutil.FormatKey(key, ref.Database))
 }
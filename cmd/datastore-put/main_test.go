@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+)
+
+func TestNewKeyRef(t *testing.T) {
+	parent := datastore.NameKey("Parent", "p", nil)
+	parent.Namespace = "ns1"
+
+	tests := []struct {
+		name     string
+		ns       string
+		ancestor dsutil.KeyRef
+		want     string
+		wantErr  string
+	}{
+		{name: "root", want: "Kind/k"},
+		{name: "root in namespace", ns: "ns1", ancestor: dsutil.KeyRef{Database: "db1"}, want: "db:db1/ns:ns1/Kind/k"},
+		{name: "inherit namespace", ancestor: dsutil.KeyRef{Key: parent}, want: "ns:ns1/Parent/p/Kind/k"},
+		{name: "same namespace", ns: "ns1", ancestor: dsutil.KeyRef{Key: parent}, want: "ns:ns1/Parent/p/Kind/k"},
+		{name: "different namespace", ns: "ns2", ancestor: dsutil.KeyRef{Key: parent}, wantErr: `namespace "ns2" differs`},
+		{name: "incomplete ancestor", ancestor: dsutil.KeyRef{Key: datastore.IncompleteKey("Parent", nil)}, wantErr: "must be complete"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newKeyRef("Kind", "k", tt.ns, tt.ancestor)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newKeyRef: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got=%s, want %s", got, tt.want)
+			}
+		})
+	}
+}
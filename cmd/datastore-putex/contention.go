@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/report"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+type MyKind struct {
+	Name  string
+	Time  time.Time
+	Count int64
+}
+
+// hotKeys 競合させるキー。n個ならbaseの名前に-0, -1, ...を付けたキーにする
+func hotKeys(base *datastore.Key, n int) []*datastore.Key {
+	if n == 1 {
+		return []*datastore.Key{base}
+	}
+	name := base.Name
+	if name == "" {
+		name = strconv.FormatInt(base.ID, 10)
+	}
+	ret := make([]*datastore.Key, 0, n)
+	for i := 0; i < n; i++ {
+		k := datastore.NameKey(base.Kind, fmt.Sprintf("%s-%d", name, i), base.Parent)
+		k.Namespace = base.Namespace
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+// keyPicker キーのindexを選ぶ。skewが0なら一様、1より大きければzipf分布で先頭のキーほど選ばれやすい。
+// goroutine safeではないのでクライアントごとに作る
+type keyPicker struct {
+	n    int
+	rnd  *rand.Rand
+	zipf *rand.Zipf
+}
+
+func newKeyPicker(n int, skew float64, seed uint64) *keyPicker {
+	rnd := rand.New(rand.NewPCG(seed, uint64(time.Now().UnixNano())))
+	p := &keyPicker{n: n, rnd: rnd}
+	if skew > 1 && n > 1 {
+		p.zipf = rand.NewZipf(rnd, skew, 1, uint64(n-1))
+	}
+	return p
+}
+
+func (p *keyPicker) Pick() int {
+	if p.zipf != nil {
+		return int(p.zipf.Uint64())
+	}
+	return p.rnd.IntN(p.n)
+}
+
+// clientStats 1クライアント分の集計。そのクライアントのgoroutineからしか触らない
+type clientStats struct {
+	Client int `json:"client"`
+	// Transactions RunInTransactionを呼んだ回数
+	Transactions int64 `json:"transactions"`
+	// Attempts transaction funcが呼ばれた回数
+	Attempts int64 `json:"attempts"`
+	Retries  int64 `json:"retries"`
+	// Commits 書き込みトランザクションの成功数
+	Commits int64 `json:"commits"`
+	// ReadOnly 読み取り専用トランザクションの成功数
+	ReadOnly int64 `json:"readOnly"`
+	// Aborts 競合したままMaxAttemptsを使い切った数
+	Aborts int64 `json:"aborts"`
+	Errors int64 `json:"errors"`
+
+	// latency トランザクションごとのレイテンシとエラー
+	latency *report.Report
+}
+
+// contention 各クライアントが同じキー群に対してread-modify-writeのトランザクションを繰り返す
+type contention struct {
+	keys        []*datastore.Key
+	skew        float64
+	maxAttempts int
+	readOnly    float64
+	// committed キーごとの書き込みトランザクションの成功数。整合性の確認に使う
+	committed []int64
+}
+
+func newContention(keys []*datastore.Key, skew float64, maxAttempts int, readOnly float64) *contention {
+	return &contention{
+		keys:        keys,
+		skew:        skew,
+		maxAttempts: maxAttempts,
+		readOnly:    readOnly,
+		committed:   make([]int64, len(keys)),
+	}
+}
+
+// run cont()がfalseを返すまでトランザクションを繰り返す
+func (c *contention) run(ctx context.Context, cl *datastore.Client, index int, cont func(n int64) bool) *clientStats {
+	stats := &clientStats{Client: index, latency: report.New()}
+	picker := newKeyPicker(len(c.keys), c.skew, uint64(index))
+	name := fmt.Sprintf("client-%d", index)
+
+	for ; cont(stats.Transactions) && ctx.Err() == nil; stats.Transactions++ {
+		ki := picker.Pick()
+		key := c.keys[ki]
+		readOnly := c.readOnly > 0 && picker.rnd.Float64() < c.readOnly
+
+		opts := []datastore.TransactionOption{datastore.MaxAttempts(c.maxAttempts)}
+		if readOnly {
+			opts = append(opts, datastore.ReadOnly)
+		}
+
+		began := time.Now()
+		_, err := cl.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			// RunInTransactionに渡したfuncは競合すると複数回実行される
+			stats.Attempts++
+
+			var rec MyKind
+			if err := tx.Get(key, &rec); err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+				return err
+			}
+			if readOnly {
+				return nil
+			}
+			rec.Name = "counter of " + key.String()
+			rec.Time = time.Now().UTC()
+			rec.Count++
+			_, err := tx.Put(key, &rec)
+			return err
+		}, opts...)
+
+		r := &vegeta.Result{
+			Attack:    name,
+			Timestamp: began,
+			Latency:   time.Since(began),
+			Code:      http.StatusOK,
+		}
+		switch {
+		case err == nil && readOnly:
+			stats.ReadOnly++
+		case err == nil:
+			stats.Commits++
+			atomic.AddInt64(&c.committed[ki], 1)
+		case errors.Is(err, datastore.ErrConcurrentTransaction):
+			stats.Aborts++
+		default:
+			stats.Errors++
+			logger.Warnf("%s: RunInTransaction: %v", name, err)
+		}
+		if err != nil {
+			r.Code = http.StatusInternalServerError
+			r.Error = err.Error()
+		}
+		stats.latency.Add(r)
+	}
+	stats.Retries = stats.Attempts - stats.Transactions
+	return stats
+}
+
+// getCounts 存在しないキーは0とみなす
+func getCounts(ctx context.Context, cl *datastore.Client, keys []*datastore.Key) ([]int64, error) {
+	recs := make([]MyKind, len(keys))
+	err := cl.GetMulti(ctx, keys, recs)
+	var me datastore.MultiError
+	if errors.As(err, &me) {
+		for i, e := range me {
+			if e != nil && !errors.Is(e, datastore.ErrNoSuchEntity) {
+				return nil, fmt.Errorf("GetMulti: %s: %w", keys[i], e)
+			}
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("GetMulti: %w", err)
+	}
+
+	ret := make([]int64, len(keys))
+	for i, e := range recs {
+		ret[i] = e.Count
+	}
+	return ret, nil
+}
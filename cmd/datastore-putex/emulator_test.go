@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/tckz/go-gcp-playground/internal/dsutil/dstest"
+)
+
+func TestMain(m *testing.M) {
+	dstest.Main(m, main)
+}
+
+// TestPutexEmulator 全トランザクションがcommitされ、カウンタの合計がその数に一致する
+func TestPutexEmulator(t *testing.T) {
+	const (
+		keys         = 2
+		clients      = 4
+		transactions = 5
+	)
+	ctx := context.Background()
+	for _, c := range dstest.Cases() {
+		t.Run(c.Name, func(t *testing.T) {
+			cl := dstest.Client(t, c.Database)
+			kind := dstest.Unique("Race")
+			// 競合で諦めるトランザクションが出ないだけのリトライを許す
+			args := append(c.Args(), "--kind", kind, "--name", "r",
+				"--keys", strconv.Itoa(keys), "--clients", strconv.Itoa(clients),
+				"--transactions", strconv.Itoa(transactions), "--max-attempts", "100", "--report", "json")
+			out, err := dstest.Run(t, args...)
+			if err != nil {
+				t.Fatalf("Run(%v): %v", args, err)
+			}
+			var rep struct {
+				Clients    []json.RawMessage `json:"clients"`
+				Total      clientStats       `json:"total"`
+				Keys       []keyResult       `json:"keys"`
+				Mismatches int               `json:"mismatches"`
+			}
+			if err := json.Unmarshal([]byte(out), &rep); err != nil {
+				t.Fatalf("json.Unmarshal: %v, %s", err, out)
+			}
+			if rep.Mismatches != 0 {
+				t.Errorf("mismatches=%d, keys=%+v", rep.Mismatches, rep.Keys)
+			}
+			if rep.Total.Commits != clients*transactions || len(rep.Clients) != clients {
+				t.Errorf("commits=%d, clients=%d, want %d, %d", rep.Total.Commits, len(rep.Clients), clients*transactions, clients)
+			}
+
+			counts, err := getCounts(ctx, cl, hotKeys(c.Key(kind, "r", nil), keys))
+			if err != nil {
+				t.Fatalf("getCounts: %v", err)
+			}
+			var sum int64
+			for _, n := range counts {
+				sum += n
+			}
+			if sum != clients*transactions {
+				t.Errorf("counts=%v, sum=%d, want %d", counts, sum, clients*transactions)
+			}
+		})
+	}
+}
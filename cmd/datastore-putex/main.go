@@ -2,17 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
 	"github.com/tckz/go-gcp-playground/internal/log"
 	"go.uber.org/zap"
 )
@@ -25,98 +26,169 @@ var (
 
 var (
 	optLogLevel  = flag.String("log-level", "info", "info|warn|error")
+	optClient    = dsutil.NewClientOptions(flag.CommandLine)
 	optNameSpace = flag.String("ns", "", "namespace")
 	optName      = flag.String("name", "", "named key")
 	optKind      = flag.String("kind", "mykind", "namespace")
+	optKey       = &dsutil.KeyFlag{}
+
+	optClients      = flag.Int("clients", 2, "Number of clients, each has its own connection")
+	optKeys         = flag.Int("keys", 1, "Number of hot keys, named NAME-0, NAME-1, ... if more than 1")
+	optSkew         = flag.Float64("skew", 0, "zipf s of key selection, > 1 to concentrate on NAME-0 [0 = uniform]")
+	optTransactions = flag.Int64("transactions", 1, "Number of transactions per client")
+	optDuration     = flag.Duration("duration", 0, "run transactions until this duration elapses instead of --transactions [0 = --transactions]")
+	optMaxAttempts  = flag.Int("max-attempts", 3, "MaxAttempts of each transaction")
+	optReadOnly     = flag.Float64("read-only", 0, "ratio of read-only transactions which only get the counter, 0..1")
+	optReport       = flag.String("report", "text", "text|json|none, report of counts and latency per client and the consistency check to stdout")
 )
 
 func init() {
 	godotenv.Load()
 
-	flag.Parse()
-
-	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+	flag.Var(optKey, "key", "[db:DATABASE/][ns:NAMESPACE/]Kind/ident/... or encoded key, instead of --kind and --name")
 }
 
-type MyKind struct {
-	Name string
-	Time time.Time
+func validate() error {
+	switch {
+	case *optClients <= 0:
+		return errors.New("--clients must be > 0")
+	case *optKeys <= 0 || *optKeys > 1000:
+		// 整合性の確認で一度にGetMultiできる数まで
+		return errors.New("--keys must be in 1..1000")
+	case *optSkew != 0 && *optSkew <= 1:
+		return errors.New("--skew must be > 1 or 0")
+	case *optMaxAttempts <= 0:
+		return errors.New("--max-attempts must be > 0")
+	case *optReadOnly < 0 || *optReadOnly > 1:
+		return errors.New("--read-only must be in 0..1")
+	}
+	switch *optReport {
+	case "text", "json", "none":
+	default:
+		return fmt.Errorf("unknown report format: %s", *optReport)
+	}
+	return nil
 }
 
 func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
 	logger.Infof("ver=%s, args=%s", version, os.Args)
 
+	if err := validate(); err != nil {
+		logger.Fatalf("*** %v", err)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	pjID := os.Getenv("PROJECT_ID")
+	ref := optKey.KeyRef
+	if ref.Key == nil {
+		name := *optName
+		if name == "" {
+			name = uuid.New().String()
+		}
+		ref.Key = datastore.NameKey(*optKind, name, nil)
+		ref.Key.Namespace = *optNameSpace
+	}
+	if ref.Key.Incomplete() {
+		logger.Fatalf("*** key must be complete: %s", ref)
+	}
+	database, err := optClient.DatabaseOf(ref.Database)
+	if err != nil {
+		logger.Fatalf("*** %v", err)
+	}
+	ref.Database = database
+	logger.Infof("key=%s", ref)
 
-	name := *optName
-	if name == "" {
-		name = uuid.New().String()
+	if err := run(ctx, ref); err != nil {
+		logger.Errorf("*** %v", err)
+		os.Exit(1)
 	}
+}
 
-	wg := &sync.WaitGroup{}
+func run(ctx context.Context, ref dsutil.KeyRef) error {
+	keys := hotKeys(ref.Key, *optKeys)
+
+	// ざっと見た感じ別インスタンスを作ればコネクションも別っぽいので1プロセスで確認できそう
+	clients := make([]*datastore.Client, 0, *optClients)
+	defer func() {
+		for _, cl := range clients {
+			cl.Close()
+		}
+	}()
+	for i := 0; i < *optClients; i++ {
+		cl, err := optClient.NewClient(context.Background(), "", ref.Database)
+		if err != nil {
+			return fmt.Errorf("optClient.NewClient: %w", err)
+		}
+		clients = append(clients, cl)
+	}
+
+	initial, err := getCounts(ctx, clients[0], keys)
+	if err != nil {
+		return fmt.Errorf("getCounts: %w", err)
+	}
+
+	c := newContention(keys, *optSkew, *optMaxAttempts, *optReadOnly)
+	stats := make([]*clientStats, len(clients))
 
-	// 通信環境や実行時コア数によると思うが自分の環境では2つで十分再現できる
-	numGoroutines := 2
-	counter := make([]int64, numGoroutines)
-	for i := 0; i < numGoroutines; i++ {
-		index := i
+	// 接続を作り終えてから一斉に始めないと競合しにくい
+	began := time.Now()
+	cont := func(n int64) bool {
+		if *optDuration > 0 {
+			return time.Since(began) < *optDuration
+		}
+		return n < *optTransactions
+	}
+	wg := &sync.WaitGroup{}
+	for i, cl := range clients {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-
-			logger := logger.With(zap.String("index", fmt.Sprintf("%d", index)))
-
-			// ざっと見た感じ別インスタンスを作ればコネクションも別っぽいので1プロセスで確認できそう
-			cl, err := datastore.NewClient(context.Background(), pjID)
-			if err != nil {
-				logger.Fatalf("*** datastore.NewClient: %v", err)
-			}
-			defer cl.Close()
-
-			key := datastore.NameKey(*optKind, name, nil)
-			key.Namespace = *optNameSpace
-
-			alreadyExist := false
-			_, err = cl.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
-				// RunInTransactionに渡したfuncは複数回実行されうる
-				logger.Infof("begin transaction")
-				atomic.AddInt64(&counter[index], 1)
-
-				alreadyExist = false
-				var rec MyKind
-				err := tx.Get(key, &rec)
-				logger.Infof("Get: err=%v", err)
-				if err == nil {
-					alreadyExist = true
-					return nil
-				} else if err != datastore.ErrNoSuchEntity {
-					return err
-				}
-
-				_, err = tx.Put(key, &MyKind{
-					Name: "myname is " + name,
-					Time: time.Now().UTC(),
-				})
-				logger.Infof("Put: err=%v", err)
-				return err
-			})
-
-			logger.Infof("alreadyExist=%t, err=%v", alreadyExist, err)
+			stats[i] = c.run(ctx, cl, i, cont)
 		}()
 	}
-
-	logger.Infof("waiting all goroutines are done")
+	logger.Infof("waiting all clients are done")
 	wg.Wait()
+	dur := time.Since(began)
 
-	// トランザクションに負けた側は2回以上実行される
-	/*
-	 transaction func for index=0 called 2 times
-	 transaction func for index=1 called 1 times
-	*/
-	for i, e := range counter {
-		logger.Infof("transaction func for index=%d called %d times", i, e)
+	keyResults, err := check(ctx, clients[0], keys, initial, c.committed, ref.Database)
+	if err != nil {
+		return err
+	}
+	rep := newContentionReport(stats, keyResults)
+	logger.Infof("total: transactions=%d, attempts=%d, retries=%d, commits=%d, readOnly=%d, aborts=%d, errors=%d, dur=%s",
+		rep.Total.Transactions, rep.Total.Attempts, rep.Total.Retries, rep.Total.Commits, rep.Total.ReadOnly, rep.Total.Aborts, rep.Total.Errors, dur)
+
+	if *optReport != "none" {
+		if err := rep.Write(os.Stdout, *optReport); err != nil {
+			return fmt.Errorf("report.Write: %w", err)
+		}
+	}
+
+	if rep.Mismatches > 0 {
+		return fmt.Errorf("consistency check failed: %d of %d keys", rep.Mismatches, len(keys))
+	}
+	logger.Infof("consistency check passed")
+	return nil
+}
+
+// check カウンタが開始時の値とコミットできた数の和になっているか確かめる。
+// abort以外のエラーはコミットされたかどうか分からないので、その分はずれうる
+func check(ctx context.Context, cl *datastore.Client, keys []*datastore.Key, initial, committed []int64, database string) ([]keyResult, error) {
+	got, err := getCounts(ctx, cl, keys)
+	if err != nil {
+		return nil, fmt.Errorf("getCounts: %w", err)
+	}
+	ret := make([]keyResult, 0, len(keys))
+	for i, k := range keys {
+		r := keyResult{Key: dsutil.FormatKey(k, database), Initial: initial[i], Committed: committed[i], Count: got[i]}
+		if !r.Consistent() {
+			logger.Errorf("key=%s, initial=%d, committed=%d, want=%d, got=%d", r.Key, r.Initial, r.Committed, r.Initial+r.Committed, r.Count)
+		}
+		ret = append(ret, r)
 	}
+	return ret, nil
 }
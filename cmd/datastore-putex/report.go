@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// clientReport クライアントごとの件数とレイテンシ
+type clientReport struct {
+	*clientStats
+	Latency     vegeta.LatencyMetrics `json:"latency"`
+	ErrorCounts map[string]int        `json:"errorCounts,omitempty"`
+}
+
+// keyResult 整合性の確認の結果
+type keyResult struct {
+	Key       string `json:"key"`
+	Initial   int64  `json:"initial"`
+	Committed int64  `json:"committed"`
+	Count     int64  `json:"count"`
+}
+
+func (r keyResult) Consistent() bool {
+	return r.Initial+r.Committed == r.Count
+}
+
+// contentionReport --reportで出力するもの
+type contentionReport struct {
+	Clients    []*clientReport `json:"clients"`
+	Total      clientStats     `json:"total"`
+	Keys       []keyResult     `json:"keys"`
+	Mismatches int             `json:"mismatches"`
+}
+
+func newContentionReport(stats []*clientStats, keys []keyResult) *contentionReport {
+	r := &contentionReport{Total: clientStats{Client: -1}, Keys: keys}
+	for _, s := range stats {
+		rep := s.latency
+		rep.Metrics.Close()
+		r.Clients = append(r.Clients, &clientReport{
+			clientStats: s,
+			Latency:     rep.Metrics.Latencies,
+			ErrorCounts: rep.ErrorCounts,
+		})
+		r.Total.Transactions += s.Transactions
+		r.Total.Attempts += s.Attempts
+		r.Total.Retries += s.Retries
+		r.Total.Commits += s.Commits
+		r.Total.ReadOnly += s.ReadOnly
+		r.Total.Aborts += s.Aborts
+		r.Total.Errors += s.Errors
+	}
+	for _, k := range keys {
+		if !k.Consistent() {
+			r.Mismatches++
+		}
+	}
+	return r
+}
+
+// Write formatは text|json
+func (r *contentionReport) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		if err := json.NewEncoder(w).Encode(r); err != nil {
+			return fmt.Errorf("json.Encode: %w", err)
+		}
+		return nil
+	case "text":
+		line := func(name string, s *clientStats) string {
+			return fmt.Sprintf("%-10s transactions=%d, attempts=%d, retries=%d, commits=%d, readOnly=%d, aborts=%d, errors=%d",
+				name, s.Transactions, s.Attempts, s.Retries, s.Commits, s.ReadOnly, s.Aborts, s.Errors)
+		}
+		for _, c := range r.Clients {
+			l := c.Latency
+			if _, err := fmt.Fprintf(w, "%s\n%-10s latency mean=%s, 50th=%s, 95th=%s, 99th=%s, max=%s\n",
+				line(fmt.Sprintf("client-%d", c.Client), c.clientStats), "", l.Mean, l.P50, l.P95, l.P99, l.Max); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w, line("total", &r.Total)); err != nil {
+			return err
+		}
+		for _, k := range r.Keys {
+			status := "ok"
+			if !k.Consistent() {
+				status = "MISMATCH"
+			}
+			if _, err := fmt.Fprintf(w, "key=%s, initial=%d, committed=%d, count=%d, %s\n", k.Key, k.Initial, k.Committed, k.Count, status); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
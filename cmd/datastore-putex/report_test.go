@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tckz/go-gcp-playground/internal/report"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func testStats(client int, commits, aborts int64, latencies ...time.Duration) *clientStats {
+	s := &clientStats{
+		Client:       client,
+		Transactions: commits + aborts,
+		Attempts:     commits + aborts*3,
+		Commits:      commits,
+		Aborts:       aborts,
+		latency:      report.New(),
+	}
+	s.Retries = s.Attempts - s.Transactions
+	for _, l := range latencies {
+		r := &vegeta.Result{Timestamp: time.Now(), Latency: l, Code: 200}
+		s.latency.Add(r)
+	}
+	for i := int64(0); i < aborts; i++ {
+		s.latency.Add(&vegeta.Result{Timestamp: time.Now(), Latency: time.Second, Code: 500, Error: "aborted"})
+	}
+	return s
+}
+
+func TestContentionReportJSON(t *testing.T) {
+	stats := []*clientStats{
+		testStats(0, 2, 0, 10*time.Millisecond, 30*time.Millisecond),
+		testStats(1, 1, 1, 20*time.Millisecond),
+	}
+	keys := []keyResult{
+		{Key: "K/'a'", Initial: 1, Committed: 2, Count: 3},
+		{Key: "K/'b'", Initial: 0, Committed: 1, Count: 0},
+	}
+	rep := newContentionReport(stats, keys)
+
+	var buf bytes.Buffer
+	if err := rep.Write(&buf, "json"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	var got struct {
+		Clients []struct {
+			Client      int            `json:"client"`
+			Commits     int64          `json:"commits"`
+			Aborts      int64          `json:"aborts"`
+			Retries     int64          `json:"retries"`
+			ErrorCounts map[string]int `json:"errorCounts"`
+			Latency     struct {
+				Mean time.Duration `json:"mean"`
+				Max  time.Duration `json:"max"`
+			} `json:"latency"`
+		} `json:"clients"`
+		Total struct {
+			Transactions int64 `json:"transactions"`
+			Commits      int64 `json:"commits"`
+			Aborts       int64 `json:"aborts"`
+			Retries      int64 `json:"retries"`
+		} `json:"total"`
+		Keys       []keyResult `json:"keys"`
+		Mismatches int         `json:"mismatches"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal: %v, %s", err, buf.String())
+	}
+
+	if len(got.Clients) != 2 {
+		t.Fatalf("clients=%d, want 2", len(got.Clients))
+	}
+	c0, c1 := got.Clients[0], got.Clients[1]
+	if c0.Client != 0 || c0.Commits != 2 || c0.Aborts != 0 || c0.Latency.Mean != 20*time.Millisecond || c0.Latency.Max != 30*time.Millisecond {
+		t.Errorf("client 0=%+v", c0)
+	}
+	if c1.Client != 1 || c1.Commits != 1 || c1.Aborts != 1 || c1.Retries != 2 || c1.ErrorCounts["aborted"] != 1 || c1.Latency.Max != time.Second {
+		t.Errorf("client 1=%+v", c1)
+	}
+	if got.Total.Transactions != 4 || got.Total.Commits != 3 || got.Total.Aborts != 1 || got.Total.Retries != 2 {
+		t.Errorf("total=%+v", got.Total)
+	}
+	if got.Mismatches != 1 || len(got.Keys) != 2 {
+		t.Errorf("mismatches=%d, keys=%v", got.Mismatches, got.Keys)
+	}
+}
+
+func TestContentionReportText(t *testing.T) {
+	rep := newContentionReport(
+		[]*clientStats{testStats(0, 1, 0, 10*time.Millisecond), testStats(1, 0, 1)},
+		[]keyResult{{Key: "K/'a'", Committed: 1, Count: 1}, {Key: "K/'b'", Committed: 1, Count: 2}},
+	)
+	var buf bytes.Buffer
+	if err := rep.Write(&buf, "text"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"client-0   transactions=1, attempts=1, retries=0, commits=1",
+		"client-1   transactions=1, attempts=3, retries=2, commits=0, readOnly=0, aborts=1",
+		"latency mean=10ms",
+		"total      transactions=2",
+		"key=K/'a', initial=0, committed=1, count=1, ok",
+		"key=K/'b', initial=0, committed=1, count=2, MISMATCH",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	if err := rep.Write(&buf, "xml"); err == nil {
+		t.Errorf("Write must fail on unknown format")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+	datastorepb "google.golang.org/genproto/googleapis/datastore/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type aggregation struct {
+	alias    string
+	op       string
+	property string
+}
+
+// aggregationsFlag [ALIAS=]count|sum(PROP)|avg(PROP)
+type aggregationsFlag struct {
+	values       []string
+	aggregations []aggregation
+}
+
+var aggregationPattern = regexp.MustCompile(`^(?:([^=]+)=)?(count|sum|avg)(?:\((.+)\))?$`)
+
+func (f *aggregationsFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *aggregationsFlag) Set(s string) error {
+	m := aggregationPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return fmt.Errorf("must be [ALIAS=]count|sum(PROP)|avg(PROP): %s", s)
+	}
+	a := aggregation{alias: m[1], op: m[2], property: m[3]}
+	if (a.op == "count") != (a.property == "") {
+		return fmt.Errorf("sum and avg need a property, count takes none: %s", s)
+	}
+	if a.alias == "" {
+		a.alias = a.op
+		if a.property != "" {
+			a.alias += "_" + a.property
+		}
+	}
+
+	f.values = append(f.values, s)
+	f.aggregations = append(f.aggregations, a)
+	return nil
+}
+
+// countOnly keysOnlyで数えて代替できるものだけか
+func (f *aggregationsFlag) countOnly() bool {
+	for _, a := range f.aggregations {
+		if a.op != "count" {
+			return false
+		}
+	}
+	return true
+}
+
+func runAggregation(ctx context.Context, cl *datastore.Client, q *datastore.Query, aggs []aggregation) (map[string]interface{}, error) {
+	aq := q.NewAggregationQuery()
+	for _, a := range aggs {
+		switch a.op {
+		case "count":
+			aq = aq.WithCount(a.alias)
+		case "sum":
+			aq = aq.WithSum(a.property, a.alias)
+		case "avg":
+			aq = aq.WithAvg(a.property, a.alias)
+		}
+	}
+
+	res, err := cl.RunAggregationQuery(ctx, aq)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]interface{}, len(res))
+	for k, v := range res {
+		pv, ok := v.(*datastorepb.Value)
+		if !ok {
+			return nil, fmt.Errorf("unexpected aggregation result type: %s=%T", k, v)
+		}
+		switch t := pv.GetValueType().(type) {
+		case *datastorepb.Value_IntegerValue:
+			ret[k] = t.IntegerValue
+		case *datastorepb.Value_DoubleValue:
+			ret[k] = t.DoubleValue
+		case *datastorepb.Value_NullValue:
+			// 対象が0件のavgなど
+			ret[k] = nil
+		default:
+			return nil, fmt.Errorf("unexpected aggregation value type: %s=%T", k, t)
+		}
+	}
+	return ret, nil
+}
+
+func isUnimplemented(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}
+
+// aggregate 結果をaliasをキーとするmapで返す
+func aggregate(ctx context.Context, cl *datastore.Client, q *datastore.Query, f *aggregationsFlag, forceCountKeys bool, logStep int64) (map[string]interface{}, error) {
+	if !forceCountKeys {
+		res, err := runAggregation(ctx, cl, q, f.aggregations)
+		if err == nil {
+			return res, nil
+		}
+		if !isUnimplemented(err) || !f.countOnly() {
+			return nil, fmt.Errorf("runAggregation: %w", err)
+		}
+		logger.Warnf("aggregation query is not available, fall back to counting keys: %v", err)
+	}
+
+	if !f.countOnly() {
+		return nil, errors.New("only count can be computed by counting keys")
+	}
+	n, err := dsutil.CountKeys(ctx, cl, q, logStep, func(n int64) {
+		logger.Infof("counted=%d", n)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dsutil.CountKeys: %w", err)
+	}
+	ret := map[string]interface{}{}
+	for _, a := range f.aggregations {
+		ret[a.alias] = n
+	}
+	if len(ret) == 0 {
+		ret["count"] = n
+	}
+	return ret, nil
+}
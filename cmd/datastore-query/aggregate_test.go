@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"go.uber.org/zap"
+	"google.golang.org/api/option"
+	datastorepb "google.golang.org/genproto/googleapis/datastore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestAggregationsFlagSet(t *testing.T) {
+	tests := []struct {
+		in      []string
+		want    []aggregation
+		wantErr string
+	}{
+		{in: []string{"count"}, want: []aggregation{{alias: "count", op: "count"}}},
+		{in: []string{" n=count "}, want: []aggregation{{alias: "n", op: "count"}}},
+		{in: []string{"sum(Price)"}, want: []aggregation{{alias: "sum_Price", op: "sum", property: "Price"}}},
+		{in: []string{"avg(Price)"}, want: []aggregation{{alias: "avg_Price", op: "avg", property: "Price"}}},
+		{in: []string{"total=sum(a.b)", "count"}, want: []aggregation{{alias: "total", op: "sum", property: "a.b"}, {alias: "count", op: "count"}}},
+		{in: []string{"count(Price)"}, wantErr: "count takes none"},
+		{in: []string{"sum"}, wantErr: "sum and avg need a property"},
+		{in: []string{"avg()"}, wantErr: "must be [ALIAS=]count|sum(PROP)|avg(PROP)"},
+		{in: []string{"max(Price)"}, wantErr: "must be [ALIAS=]count|sum(PROP)|avg(PROP)"},
+		{in: []string{"=count"}, wantErr: "must be [ALIAS=]count|sum(PROP)|avg(PROP)"},
+		{in: []string{""}, wantErr: "must be [ALIAS=]count|sum(PROP)|avg(PROP)"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.in, ","), func(t *testing.T) {
+			var f aggregationsFlag
+			var err error
+			for _, s := range tt.in {
+				if err = f.Set(s); err != nil {
+					break
+				}
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if !reflect.DeepEqual(f.aggregations, tt.want) {
+				t.Errorf("got=%+v, want %+v", f.aggregations, tt.want)
+			}
+			if f.String() != strings.Join(tt.in, ",") {
+				t.Errorf("String=%s", f.String())
+			}
+		})
+	}
+}
+
+// fakeDatastore 集計クエリはaggErrで失敗し、キーだけのクエリはkeys件を返す
+type fakeDatastore struct {
+	datastorepb.UnimplementedDatastoreServer
+	aggErr error
+	keys   int
+}
+
+func (s *fakeDatastore) RunAggregationQuery(context.Context, *datastorepb.RunAggregationQueryRequest) (*datastorepb.RunAggregationQueryResponse, error) {
+	return nil, s.aggErr
+}
+
+func (s *fakeDatastore) RunQuery(_ context.Context, req *datastorepb.RunQueryRequest) (*datastorepb.RunQueryResponse, error) {
+	var results []*datastorepb.EntityResult
+	for i := 0; i < s.keys; i++ {
+		results = append(results, &datastorepb.EntityResult{Entity: &datastorepb.Entity{Key: &datastorepb.Key{
+			PartitionId: &datastorepb.PartitionId{ProjectId: req.GetProjectId()},
+			Path:        []*datastorepb.Key_PathElement{{Kind: "K", IdType: &datastorepb.Key_PathElement_Id{Id: int64(i + 1)}}},
+		}}})
+	}
+	return &datastorepb.RunQueryResponse{Batch: &datastorepb.QueryResultBatch{
+		EntityResultType: datastorepb.EntityResult_KEY_ONLY,
+		EntityResults:    results,
+		MoreResults:      datastorepb.QueryResultBatch_NO_MORE_RESULTS,
+	}}, nil
+}
+
+func newFakeClient(t *testing.T, srv *fakeDatastore) *datastore.Client {
+	t.Helper()
+	t.Setenv("DATASTORE_EMULATOR_HOST", "")
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer()
+	datastorepb.RegisterDatastoreServer(gs, srv)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := datastore.NewClient(context.Background(), "p", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatalf("datastore.NewClient: %v", err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func TestAggregateFallback(t *testing.T) {
+	logger = zap.NewNop().Sugar()
+	unimplemented := status.Error(codes.Unimplemented, "no aggregation")
+	tests := []struct {
+		name           string
+		aggs           []string
+		aggErr         error
+		forceCountKeys bool
+		want           map[string]interface{}
+		wantErr        string
+	}{
+		{name: "unimplemented count", aggs: []string{"n=count", "count"}, aggErr: unimplemented, want: map[string]interface{}{"n": int64(3), "count": int64(3)}},
+		{name: "force count keys", aggs: []string{"n=count"}, aggErr: status.Error(codes.Internal, "not called"), forceCountKeys: true, want: map[string]interface{}{"n": int64(3)}},
+		{name: "force count keys without aggregate", forceCountKeys: true, want: map[string]interface{}{"count": int64(3)}},
+		{name: "unimplemented sum", aggs: []string{"count", "sum(A)"}, aggErr: unimplemented, wantErr: "runAggregation"},
+		{name: "other error", aggs: []string{"count"}, aggErr: status.Error(codes.InvalidArgument, "bad"), wantErr: "InvalidArgument"},
+		{name: "force count keys with avg", aggs: []string{"avg(A)"}, forceCountKeys: true, wantErr: "only count can be computed by counting keys"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := newFakeClient(t, &fakeDatastore{aggErr: tt.aggErr, keys: 3})
+			var f aggregationsFlag
+			for _, s := range tt.aggs {
+				if err := f.Set(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := aggregate(context.Background(), cl, datastore.NewQuery("K"), &f, tt.forceCountKeys, 0)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("aggregate: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got=%v, want %v", got, tt.want)
+			}
+		})
+	}
+}
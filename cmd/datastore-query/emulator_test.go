@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+	"github.com/tckz/go-gcp-playground/internal/dsutil/dstest"
+)
+
+func TestMain(m *testing.M) {
+	dstest.Main(m, main)
+}
+
+func lines(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == '\n' })
+}
+
+func TestQueryEmulator(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range dstest.Cases() {
+		t.Run(c.Name, func(t *testing.T) {
+			cl := dstest.Client(t, c.Database)
+			kind := dstest.Unique("Item")
+			a := c.Key(kind, "a", nil)
+			keys := []*datastore.Key{a, c.Key(kind, "b", nil), c.Key(kind, "c", nil), c.Key("Child", "d", a)}
+			props := []datastore.PropertyList{
+				{{Name: "Name", Value: "a"}},
+				{{Name: "Name", Value: "b"}},
+				{{Name: "Name", Value: "c"}},
+				{{Name: "Name", Value: "d"}},
+			}
+			if _, err := cl.PutMulti(ctx, keys, props); err != nil {
+				t.Fatalf("PutMulti: %v", err)
+			}
+
+			tests := []struct {
+				name  string
+				args  []string
+				want  int
+				check func(t *testing.T, line string)
+			}{
+				{
+					name: "all",
+					args: append(c.Args(), "--kind", kind),
+					want: 3,
+					check: func(t *testing.T, line string) {
+						var ent dsutil.Entity
+						if err := json.Unmarshal([]byte(line), &ent); err != nil {
+							t.Fatalf("json.Unmarshal: %v, %s", err, line)
+						}
+						var pid dsutil.PartitionID
+						if ent.Key.PartitionID != nil {
+							pid = *ent.Key.PartitionID
+						}
+						if pid.DatabaseID != c.Database || pid.NamespaceID != c.Namespace {
+							t.Errorf("partitionId=%+v, want database=%s, namespace=%s", pid, c.Database, c.Namespace)
+						}
+					},
+				},
+				{name: "keys only", args: append(c.Args(), "--kind", kind, "--keys-only"), want: 3},
+				{name: "filter", args: append(c.Args(), "--kind", kind, "--filter", `Name = "b"`), want: 1},
+				{name: "ancestor", args: []string{"--kind", "Child", "--ancestor", c.KeyString(a)}, want: 1},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					out, err := dstest.Run(t, tt.args...)
+					if err != nil {
+						t.Fatalf("Run(%v): %v", tt.args, err)
+					}
+					l := lines(out)
+					if len(l) != tt.want {
+						t.Fatalf("%d entities, want %d: %s", len(l), tt.want, out)
+					}
+					if tt.check != nil {
+						for _, e := range l {
+							tt.check(t, e)
+						}
+					}
+				})
+			}
+
+			out, err := dstest.Run(t, append(c.Args(), "--kind", kind, "--count-keys")...)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if got := strings.TrimSpace(out); got != `{"count":3}` {
+				t.Errorf("--count-keys=%s", got)
+			}
+
+			// 途中で失敗したら0以外で終わる
+			for _, args := range [][]string{
+				append(c.Args(), "--kind", kind, "--cursor-output", filepath.Join(t.TempDir(), "no", "such", "dir")),
+				append(c.Args(), "--kind", kind, "--count-keys", "--aggregate", "sum(Name)"),
+			} {
+				if _, err := dstest.Run(t, args...); err == nil {
+					t.Errorf("Run(%v) must fail", args)
+				}
+			}
+		})
+	}
+}
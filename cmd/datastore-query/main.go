@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
+	"strings"
 
 	"cloud.google.com/go/datastore"
 	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
 	"github.com/tckz/go-gcp-playground/internal/log"
 	"go.uber.org/zap"
 	"google.golang.org/api/iterator"
@@ -23,52 +25,174 @@ var (
 )
 
 var (
-	optLogLevel  = flag.String("log-level", "info", "info|warn|error")
-	optNameSpace = flag.String("ns", "", "namespace")
-	optKind      = flag.String("kind", "mykind", "namespace")
+	optLogLevel = flag.String("log-level", "info", "info|warn|error")
+	optClient   = dsutil.NewClientOptions(flag.CommandLine)
+	optQuery    = dsutil.NewQueryOptions(flag.CommandLine)
+	optPretty   = flag.Bool("pretty", false, "indent JSON output")
+
+	optOrder      = flag.String("order", "", "comma separated properties, prefix - for descending order")
+	optLimit      = flag.Int("limit", 0, "max number of entities [0 = unlimited]")
+	optOffset     = flag.Int("offset", 0, "number of entities to skip")
+	optProjection = flag.String("projection", "", "comma separated properties to project")
+	optDistinct   = flag.Bool("distinct", false, "return distinct combinations of --projection")
+	optDistinctOn = flag.String("distinct-on", "", "comma separated properties to be distinct")
+	optKeysOnly   = flag.Bool("keys-only", false, "return keys only")
+
+	optStart        = flag.String("start", "", "start cursor")
+	optEnd          = flag.String("end", "", "end cursor")
+	optCursorOutput = flag.String("cursor-output", "", "path/to/file to write the cursor after the last entity [empty = log only]")
+
+	optAggregations = &aggregationsFlag{}
+	optCountKeys    = flag.Bool("count-keys", false, "count entities by a keys-only query instead of an aggregation query, for environments like the emulator")
+	optLogStep      = flag.Int64("log-step", 10000, "progress interval of --count-keys")
 )
 
 func init() {
 	godotenv.Load()
 
-	flag.Parse()
-
-	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
-}
-
-type MyKind struct {
-	Name string
-	Time time.Time
+	flag.Var(optAggregations, "aggregate", "[ALIAS=]count|sum(PROP)|avg(PROP), print the aggregation result as JSON instead of entities, can be specified multiple times")
 }
 
 func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
 	logger.Infof("ver=%s, args=%s", version, os.Args)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	pjID := os.Getenv("PROJECT_ID")
+	q, err := newQuery()
+	if err != nil {
+		logger.Fatalf("*** newQuery: %v", err)
+	}
+
+	database, err := optClient.DatabaseOf(optQuery.Database())
+	if err != nil {
+		logger.Fatalf("*** %v", err)
+	}
 
-	cl, err := datastore.NewClient(context.Background(), pjID)
+	cl, err := optClient.NewClient(context.Background(), "", database)
 	if err != nil {
-		logger.Fatalf("*** datastore.NewClient: %v", err)
+		logger.Fatalf("*** optClient.NewClient: %v", err)
 	}
 	defer cl.Close()
 
-	q := datastore.NewQuery(*optKind).Namespace(*optNameSpace)
+	if err := run(ctx, cl, q, database); err != nil {
+		logger.Errorf("*** %v", err)
+		cl.Close()
+		os.Exit(1)
+	}
+
+	logger.Info("done")
+}
+
+func run(ctx context.Context, cl *datastore.Client, q *datastore.Query, database string) error {
+	enc := json.NewEncoder(os.Stdout)
+	if *optPretty {
+		enc.SetIndent("", "  ")
+	}
+
+	if len(optAggregations.aggregations) > 0 || *optCountKeys {
+		res, err := aggregate(ctx, cl, q, optAggregations, *optCountKeys, *optLogStep)
+		if err != nil {
+			return fmt.Errorf("aggregate: %w", err)
+		}
+		if err := enc.Encode(res); err != nil {
+			return fmt.Errorf("Encode: %w", err)
+		}
+		return nil
+	}
+
 	it := cl.Run(ctx, q)
+	count := 0
 	for {
-		var rec MyKind
-		key, err := it.Next(&rec)
+		var props datastore.PropertyList
+		key, err := it.Next(&props)
 		if errors.Is(err, iterator.Done) {
 			break
 		}
 		if err != nil {
-			logger.Errorf("Next: %v", err)
-			return
+			return fmt.Errorf("Next: %w", err)
+		}
+		ent, err := dsutil.NewEntity(key, props)
+		if err != nil {
+			return fmt.Errorf("dsutil.NewEntity: %w", err)
 		}
-		fmt.Printf("Key=%v, MyKind=%+v\n", key, rec)
+		// デフォルトのnamespaceだとPartitionIDがnilなのでNewKeyRefに任せる
+		ent.Key = dsutil.NewKeyRef(dsutil.KeyRef{Database: database, Key: key})
+		if err := enc.Encode(ent); err != nil {
+			return fmt.Errorf("Encode: %w", err)
+		}
+		count++
 	}
 
-	logger.Info("done")
+	// 最後のエンティティの次を指すので--startに渡せば続きを取得できる
+	cursor, err := it.Cursor()
+	if err != nil {
+		return fmt.Errorf("Cursor: %w", err)
+	}
+	logger.Infof("count=%d, cursor=%s", count, cursor)
+	if *optCursorOutput != "" {
+		if err := os.WriteFile(*optCursorOutput, []byte(cursor.String()+"\n"), 0644); err != nil {
+			return fmt.Errorf("os.WriteFile: %w", err)
+		}
+	}
+	return nil
+}
+
+// splitList カンマ区切りを分割する。空文字列ならnil
+func splitList(s string) []string {
+	var ret []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func newQuery() (*datastore.Query, error) {
+	q, err := optQuery.Query()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range splitList(*optOrder) {
+		q = q.Order(e)
+	}
+	if *optLimit > 0 {
+		q = q.Limit(*optLimit)
+	}
+	if *optOffset > 0 {
+		q = q.Offset(*optOffset)
+	}
+	if v := splitList(*optProjection); len(v) > 0 {
+		q = q.Project(v...)
+	}
+	if *optDistinct {
+		q = q.Distinct()
+	}
+	if v := splitList(*optDistinctOn); len(v) > 0 {
+		q = q.DistinctOn(v...)
+	}
+	if *optKeysOnly {
+		q = q.KeysOnly()
+	}
+
+	if *optStart != "" {
+		c, err := datastore.DecodeCursor(*optStart)
+		if err != nil {
+			return nil, fmt.Errorf("--start: %w", err)
+		}
+		q = q.Start(c)
+	}
+	if *optEnd != "" {
+		c, err := datastore.DecodeCursor(*optEnd)
+		if err != nil {
+			return nil, fmt.Errorf("--end: %w", err)
+		}
+		q = q.End(c)
+	}
+	return q, nil
 }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"github.com/tckz/go-gcp-playground/internal/dedup"
+	"github.com/tckz/go-gcp-playground/internal/loadtest"
+	"github.com/tckz/go-gcp-playground/internal/log"
+	vh "github.com/tckz/vegetahelper"
+	"go.uber.org/zap"
+)
+
+var (
+	myName  = filepath.Base(os.Args[0])
+	logger  *zap.SugaredLogger
+	version string
+)
+
+var (
+	optLoadTest   = loadtest.NewOptions(flag.CommandLine)
+	optLogLevel   = flag.String("log-level", "info", "info|warn|error")
+	optRedis      = flag.String("redis", "", "addr:port of redis [empty = local backends]")
+	optPoolSize   = flag.Int("pool-size", 200, "PoolSize of redis client")
+	optCounterKey = flag.String("counter-key", "dedup-bench-counter", "key of redis")
+	optDupRatio   = flag.Float64("dup-ratio", 0.1, "Ratio of hits which reuse an already acquired message ID")
+	optDupWindow  = flag.Int("dup-window", 10000, "Number of recent acquired message IDs to pick duplicates from")
+	optLocalTTL   = flag.Duration("local-ttl", 1*time.Minute, "TTL of local marker")
+	optRedisTTL   = flag.Duration("redis-ttl", 1*time.Minute, "TTL of redis marker")
+)
+
+func init() {
+	godotenv.Load()
+}
+
+var (
+	errFalseDuplicate  = errors.New("false duplicate: new message ID was not acquired")
+	errMissedDuplicate = errors.New("missed duplicate: duplicated message ID was acquired")
+)
+
+type pooledID struct {
+	id      string
+	addedAt time.Time
+}
+
+// idPool 重複として再送するためのAcquire済みのIDを直近のものだけ持つ。
+// マーカーのTTLを過ぎたIDを再送するとmissedDuplicatesに数えてしまうので、maxAgeより古いものは選ばない
+type idPool struct {
+	mu     sync.Mutex
+	ids    []pooledID
+	next   int
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+func newIDPool(size int, maxAge time.Duration) *idPool {
+	return &idPool{ids: make([]pooledID, 0, size), maxAge: maxAge, now: time.Now}
+}
+
+func (p *idPool) Add(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// ロックの中で時刻を取るので、nextから順に古い順に並ぶ
+	e := pooledID{id: id, addedAt: p.now()}
+	if len(p.ids) < cap(p.ids) {
+		p.ids = append(p.ids, e)
+		return
+	}
+	p.ids[p.next] = e
+	p.next = (p.next + 1) % len(p.ids)
+}
+
+// Pick maxAge以内に追加されたものがなければfalse
+func (p *idPool) Pick() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.ids)
+	at := func(i int) pooledID {
+		return p.ids[(p.next+i)%n]
+	}
+	cutoff := p.now().Add(-p.maxAge)
+	fresh := sort.Search(n, func(i int) bool {
+		return at(i).addedAt.After(cutoff)
+	})
+	if fresh == n {
+		return "", false
+	}
+	return at(fresh + rand.IntN(n-fresh)).id, true
+}
+
+// poolMaxAge マーカーのTTLより少し短くして、Redisとの時計のずれや処理の遅れの分を見込む
+func poolMaxAge(ttl time.Duration) time.Duration {
+	return ttl - min(ttl/10, 5*time.Second)
+}
+
+func getCounter(ctx context.Context, counter dedup.Counter) (int64, error) {
+	v, err := counter.Get(ctx)
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return v, err
+}
+
+func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+	logger.Infof("ver=%s, args=%s", version, os.Args)
+	defer logger.Infof("done")
+
+	if err := optLoadTest.Validate(); err != nil {
+		logger.Fatalf("*** %v", err)
+	}
+
+	if *optDupRatio < 0 || *optDupRatio > 1 {
+		logger.Fatalf("*** --dup-ratio must be in [0, 1].")
+	}
+
+	if *optDupWindow <= 0 {
+		logger.Fatalf("*** --dup-window must be > 0.")
+	}
+
+	ttl := *optLocalTTL
+	if *optRedis != "" {
+		ttl = *optRedisTTL
+	}
+	if ttl <= 0 {
+		logger.Fatalf("*** --local-ttl and --redis-ttl must be > 0.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var counter dedup.Counter
+	var processMarker dedup.ProcessMarker
+	if *optRedis == "" {
+		counter = &dedup.LocalCounter{}
+		processMarker = dedup.NewLocalMarker(ttl)
+	} else {
+		cl := dedup.NewRedisClient(*optRedis, *optPoolSize)
+		defer cl.Close()
+		counter = dedup.NewRedisCounter(cl, *optCounterKey)
+		processMarker = dedup.NewRedisMarker(cl, ttl)
+	}
+
+	counterBegin, err := getCounter(ctx, counter)
+	if err != nil {
+		logger.Fatalf("*** counter.Get: %v", err)
+	}
+
+	// --dup-windowの件数がTTLより前まで遡る場合はTTLの範囲に切り詰める
+	pool := newIDPool(*optDupWindow, poolMaxAge(ttl))
+	logger.Infof("ttl=%s, dup window=%d IDs within %s", ttl, *optDupWindow, pool.maxAge)
+	var acquired, duplicates, falseDuplicates, missedDuplicates int64
+	runErr := optLoadTest.Run(ctx, logger, "dedup", func(ctx context.Context) (result *vh.HitResult, retErr error) {
+		dup := rand.Float64() < *optDupRatio
+		var msgID string
+		if dup {
+			msgID, dup = pool.Pick()
+		}
+		if !dup {
+			msgID = uuid.New().String()
+		}
+
+		got, err := processMarker.Acquire(ctx, msgID)
+		if err != nil {
+			return nil, err
+		}
+
+		if dup {
+			atomic.AddInt64(&duplicates, 1)
+		}
+		switch {
+		case dup && got:
+			atomic.AddInt64(&missedDuplicates, 1)
+			return nil, errMissedDuplicate
+		case !dup && !got:
+			atomic.AddInt64(&falseDuplicates, 1)
+			return nil, errFalseDuplicate
+		case !got:
+			return result, nil
+		}
+
+		if _, err := counter.Up(ctx); err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&acquired, 1)
+		pool.Add(msgID)
+
+		return result, nil
+	})
+	if runErr != nil {
+		logger.Errorf("*** Run: %v", runErr)
+	}
+
+	{
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		counterEnd, err := getCounter(ctx, counter)
+		if err != nil {
+			logger.Errorf("*** counter.Get: %v", err)
+		}
+		// 他のプロセスが同じキーを使っていなければ増えた分とUpした回数は一致するはず
+		logger.Infof("acquired=%d, duplicates=%d, falseDuplicates=%d, missedDuplicates=%d, counterDelta=%d",
+			acquired, duplicates, falseDuplicates, missedDuplicates, counterEnd-counterBegin)
+		if counterEnd-counterBegin != acquired {
+			logger.Warnf("counter mismatch: acquired=%d, counterDelta=%d", acquired, counterEnd-counterBegin)
+		}
+	}
+
+	if runErr != nil {
+		os.Exit(1)
+	}
+}
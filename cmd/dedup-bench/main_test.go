@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIDPoolPick(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newIDPool(3, 10*time.Second)
+	p.now = func() time.Time { return now }
+
+	if _, ok := p.Pick(); ok {
+		t.Fatalf("Pick on empty pool must be false")
+	}
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		p.Add(id)
+		now = now.Add(5 * time.Second)
+	}
+	// aは追い出され、b=15s前、c=10s前、d=5s前
+	for i := 0; i < 100; i++ {
+		id, ok := p.Pick()
+		if !ok {
+			t.Fatalf("Pick=false")
+		}
+		if id != "d" {
+			t.Fatalf("Pick=%s, want only d within maxAge", id)
+		}
+	}
+
+	now = now.Add(5 * time.Second)
+	if id, ok := p.Pick(); ok {
+		t.Errorf("Pick=%s, want none after maxAge", id)
+	}
+}
+
+func TestIDPoolPickWindow(t *testing.T) {
+	p := newIDPool(2, time.Hour)
+	for _, id := range []string{"a", "b", "c"} {
+		p.Add(id)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		id, _ := p.Pick()
+		seen[id] = true
+	}
+	if seen["a"] || !seen["b"] || !seen["c"] {
+		t.Errorf("seen=%v, want b and c only", seen)
+	}
+}
+
+func TestPoolMaxAge(t *testing.T) {
+	for _, tt := range []struct {
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{time.Minute, 55 * time.Second},
+		{10 * time.Second, 9 * time.Second},
+		{time.Hour, time.Hour - 5*time.Second},
+	} {
+		if got := poolMaxAge(tt.ttl); got != tt.want {
+			t.Errorf("poolMaxAge(%s)=%s, want %s", tt.ttl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// attrFlag key=valueを複数回指定できるフラグ。valueはテンプレートとして扱う
+type attrFlag struct {
+	values []string
+}
+
+func (f *attrFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *attrFlag) Set(s string) error {
+	if k, _, ok := strings.Cut(s, "="); !ok || k == "" {
+		return fmt.Errorf("must be key=value: %s", s)
+	}
+	f.values = append(f.values, s)
+	return nil
+}
+
+type attrTemplate struct {
+	key   string
+	value string
+	// tmpl テンプレートのアクションを含まない値ならnil
+	tmpl *template.Template
+}
+
+type attrGenerator struct {
+	attrs []attrTemplate
+}
+
+func newAttrGenerator(values []string) (*attrGenerator, error) {
+	g := &attrGenerator{}
+	for _, e := range values {
+		k, v, _ := strings.Cut(e, "=")
+		a := attrTemplate{key: k, value: v}
+		if strings.Contains(v, "{{") {
+			tmpl, err := template.New(k).Funcs(templateFuncs).Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("template.Parse: key=%s, %w", k, err)
+			}
+			a.tmpl = tmpl
+		}
+		g.attrs = append(g.attrs, a)
+	}
+	sort.SliceStable(g.attrs, func(i, j int) bool { return g.attrs[i].key < g.attrs[j].key })
+	return g, nil
+}
+
+// Generate 属性の指定がなければnilを返す
+func (g *attrGenerator) Generate(d templateData) (map[string]string, error) {
+	if len(g.attrs) == 0 {
+		return nil, nil
+	}
+
+	ret := make(map[string]string, len(g.attrs))
+	for _, e := range g.attrs {
+		if e.tmpl == nil {
+			ret[e.key] = e.value
+			continue
+		}
+		var buf bytes.Buffer
+		if err := e.tmpl.Execute(&buf, d); err != nil {
+			return nil, fmt.Errorf("tmpl.Execute: key=%s, %w", e.key, err)
+		}
+		ret[e.key] = buf.String()
+	}
+	return ret, nil
+}
@@ -3,23 +3,25 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
-	"io"
+	"net/http"
 	"os"
-	"os/signal"
 	"path/filepath"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/samber/lo"
+	"github.com/tckz/go-gcp-playground/internal/e2e"
+	"github.com/tckz/go-gcp-playground/internal/loadtest"
 	"github.com/tckz/go-gcp-playground/internal/log"
+	"github.com/tckz/go-gcp-playground/internal/pubsubflag"
+	"github.com/tckz/go-gcp-playground/internal/report"
 	vh "github.com/tckz/vegetahelper"
 	vegeta "github.com/tsenart/vegeta/v12/lib"
 	"go.uber.org/zap"
-	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 var (
@@ -29,58 +31,83 @@ var (
 )
 
 var (
-	optRate = &vh.RateFlag{
-		Rate: &vegeta.Rate{
-			Freq: 30,
-			Per:  1 * time.Second,
-		}}
-	optDuration = flag.Duration("duration", 10*time.Second, "Duration of the test [0 = forever]")
-	optOutput   = flag.String("output", "", "/path/to/results.bin or 'stdout'")
-	optWorkers  = flag.Uint64("workers", vegeta.DefaultWorkers, "Number of workers")
+	optLoadTest = loadtest.NewOptions(flag.CommandLine)
+	optPublish  = pubsubflag.NewPublishSettings(flag.CommandLine)
 	optLogLevel = flag.String("log-level", "info", "info|warn|error")
 	optTopic    = flag.String("topic", "", "topic name")
+	optTopics   = flag.String("topics", "", "path/to/topics.json, [{name, weight, payloadTemplate, payloadFormat, payloadSize}], instead of --topic")
+
+	optMaxInFlight = flag.Int64("max-inflight", 1000, "Max number of publishes waiting for ack")
+	optE2EStamp    = flag.Bool("e2e-stamp", false, "stamp run ID per topic, sequence and send time as attributes for e2e latency measurement. Seqs of failed publishes are counted as missing by the subscriber, compare with failed per run in the log")
+
+	optPayloadTemplate = flag.String("payload-template", "", "path/to/template of payload, Go text/template")
+	optPayloadFormat   = flag.String("payload-format", "text", "text|json, json validates and compacts rendered payload")
+	optPayloadSize     = flag.String("payload-size", "", "size distribution of payload in bytes, fixed:N|uniform:MIN-MAX|normal:MEAN,STDDEV|exp:MEAN. text is padded with spaces or truncated to the size, json is padded with a _pad field or trailing spaces and never truncated [empty = as rendered]")
+
+	optSchemaFromTopic = flag.Bool("schema-from-topic", false, "generate records following the schema attached to the topic")
+	optSchemaFile      = flag.String("schema-file", "", "path/to/schema, generate records following the local schema instead of the topic's one")
+	optSchemaType      = flag.String("schema-type", "avro", "avro|protobuf, type of --schema-file")
+	optSchemaEncoding  = flag.String("schema-encoding", "json", "json|binary, encoding for --schema-file")
+
+	optAttrs = &attrFlag{}
+
+	optOrderingKeys      = flag.Uint64("ordering-keys", 0, "Number of ordering keys [0 = no ordering key]")
+	optOrderingKeyPrefix = flag.String("ordering-key-prefix", "key-", "prefix of ordering key")
+	optOrderingKeyDist   = flag.String("ordering-key-dist", "uniform", "uniform|zipf")
+	optZipfS             = flag.Float64("zipf-s", 1.1, "s of zipf distribution, must be > 1")
+	optZipfV             = flag.Float64("zipf-v", 1, "v of zipf distribution, must be >= 1")
 )
 
 func init() {
 	godotenv.Load()
 
-	flag.Var(optRate, "rate", "Number of requests per time unit")
+	flag.Var(optAttrs, "attr", "key=value of attribute, value can be Go text/template, can be specified multiple times")
+}
+
+func main() {
 	flag.Parse()
 
 	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
-}
 
-type nopWriteCloser struct {
-	io.Writer
-}
+	logger.Infof("ver=%s, args=%s", version, os.Args)
+	defer logger.Infof("done")
 
-func (c nopWriteCloser) Close() error {
-	return nil
-}
+	if err := optLoadTest.Validate(); err != nil {
+		logger.Fatalf("*** %v", err)
+	}
 
-func openResultFile(out string) (io.WriteCloser, error) {
-	switch out {
-	case "stdout":
-		return &nopWriteCloser{os.Stdout}, nil
-	default:
-		return os.Create(out)
+	if (*optTopic == "") == (*optTopics == "") {
+		logger.Fatalf("*** Either --topic or --topics must be specified.")
 	}
-}
 
-type MyKind struct {
-	Name string
-}
+	if *optMaxInFlight <= 0 {
+		logger.Fatalf("*** --max-inflight must be > 0.")
+	}
 
-func main() {
-	logger.Infof("ver=%s, args=%s", version, os.Args)
-	defer logger.Infof("done")
+	specs := []topicSpec{{Name: *optTopic, Weight: 1}}
+	if *optTopics != "" {
+		var err error
+		specs, err = loadTopicSpecs(*optTopics)
+		if err != nil {
+			logger.Fatalf("*** loadTopicSpecs: %v", err)
+		}
+	}
 
-	if *optOutput == "" {
-		logger.Fatalf("*** --output must be specified.")
+	attrGen, err := newAttrGenerator(optAttrs.values)
+	if err != nil {
+		logger.Fatalf("*** newAttrGenerator: %v", err)
+	}
+	keyGen, err := newOrderingKeyGenerator(*optOrderingKeyPrefix, *optOrderingKeys, *optOrderingKeyDist, *optZipfS, *optZipfV)
+	if err != nil {
+		logger.Fatalf("*** newOrderingKeyGenerator: %v", err)
 	}
 
-	if *optTopic == "" {
-		logger.Fatalf("*** --topic must be specified.")
+	runID := uuid.New().String()
+	logger.Infof("runID=%s", runID)
+
+	ps, err := optPublish.Settings()
+	if err != nil {
+		logger.Fatalf("*** optPublish.Settings: %v", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -94,78 +121,147 @@ func main() {
 	}
 	defer cl.Close()
 
-	topic := cl.Topic(*optTopic)
-	topic.PublishSettings.NumGoroutines = 30
-
-	chRes := make(chan *pubsub.PublishResult, 30)
-	eg, ctx := errgroup.WithContext(ctx)
-	var gotID int64
-	for i := 0; i < 30; i++ {
-		eg.Go(func() error {
-			for {
-				select {
-				case res, ok := <-chRes:
-					if !ok {
-						return nil
-					}
-
-					if _, err := res.Get(ctx); err != nil {
-						logger.Errorf("*** Get: %v", err)
-						return err
-					}
-					atomic.AddInt64(&gotID, 1)
-				case <-ctx.Done():
-					return ctx.Err()
-				}
-			}
-		})
+	var localSchema *schemaSpec
+	if *optSchemaFile != "" {
+		localSchema, err = loadSchemaFile(*optSchemaFile, *optSchemaType, *optSchemaEncoding)
+		if err != nil {
+			logger.Fatalf("*** loadSchemaFile: %v", err)
+		}
 	}
 
-	atk := vh.NewAttacker(func(ctx context.Context) (result *vh.HitResult, retErr error) {
-		msg := &pubsub.Message{
-			Data: []byte(fmt.Sprintf("hello: %s", uuid.New().String())),
+	logger.With(zap.Any("publishSettings", ps)).Infof("topics=%d", len(specs))
+	targets := make([]*topicTarget, 0, len(specs))
+	weights := make([]int, 0, len(specs))
+	for _, e := range specs {
+		tmplPath := lo.CoalesceOrEmpty(e.PayloadTemplate, *optPayloadTemplate)
+		tmplText, err := loadTemplate(tmplPath)
+		if err != nil {
+			logger.Fatalf("*** loadTemplate: %v", err)
 		}
-		res := topic.Publish(ctx, msg)
-		chRes <- res
+		// topicごとにseqを分けておくとsubscriber側で欠番を検出できる
+		gen, err := newPayloadGenerator(tmplText,
+			lo.CoalesceOrEmpty(e.PayloadFormat, *optPayloadFormat),
+			lo.CoalesceOrEmpty(e.PayloadSize, *optPayloadSize))
+		if err != nil {
+			logger.Fatalf("*** newPayloadGenerator: topic=%s, %v", e.Name, err)
+		}
+
+		topic := cl.Topic(e.Name)
+		topic.PublishSettings = ps
+		topic.EnableMessageOrdering = keyGen.Enabled()
+		defer topic.Stop()
 
-		return result, nil
-	}, vh.WithWorkers(*optWorkers))
-	res := atk.Attack(ctx, *optRate.Rate, *optDuration, "publish-random")
+		schema := localSchema
+		if schema == nil && *optSchemaFromTopic {
+			schema, err = topicSchema(ctx, topic)
+			if err != nil {
+				logger.Fatalf("*** topicSchema: topic=%s, %v", e.Name, err)
+			}
+		}
+		if schema != nil {
+			enc, err := newRecordEncoder(schema)
+			if err != nil {
+				logger.Fatalf("*** newRecordEncoder: topic=%s, %v", e.Name, err)
+			}
+			// テンプレートの指定があればそのJSONを、なければランダムなレコードを送る
+			gen.SetRecordEncoder(enc, tmplPath != "")
+			logger.Infof("topic=%s, schema=%s, type=%v, encoding=%v", e.Name, schema.name, schema.typ, schema.encoding)
+		}
 
-	out, err := openResultFile(*optOutput)
+		logger.Infof("topic=%s, weight=%d, payloadTemplate=%s", e.Name, e.Weight, tmplPath)
+		targets = append(targets, &topicTarget{name: e.Name, topic: topic, gen: gen, run: runID + "/" + e.Name})
+		weights = append(weights, e.Weight)
+	}
+	picker, err := newTopicPicker(targets, weights)
 	if err != nil {
-		logger.Fatal(err)
+		logger.Fatalf("*** newTopicPicker: %v", err)
 	}
-	defer out.Close()
-	enc := vegeta.NewEncoder(out)
-
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT)
-
-loop:
-	for {
-		select {
-		case s := <-sig:
-			logger.Infof("Received signal: %s", s)
-			cancel()
-			// keep loop until 'res' is closed.
-		case r, ok := <-res:
-			if !ok {
-				break loop
+	perTopic := report.NewGroup()
+
+	// ackを待つhitが同時にいくつまで滞留してよいか。埋まっている間の待ち時間もlatencyに含まれる
+	inflight := semaphore.NewWeighted(*optMaxInFlight)
+	var gotID, failed, resumed int64
+	runErr := optLoadTest.Run(ctx, logger, "publish-random", func(ctx context.Context) (result *vh.HitResult, retErr error) {
+		t := picker.Pick()
+		began := time.Now()
+		var seq int64
+		defer func() {
+			if retErr != nil && seq > 0 {
+				t.failed.Add(1)
+			}
+			r := &vegeta.Result{
+				Attack:    t.name,
+				Timestamp: began,
+				Latency:   time.Since(began),
+				Code:      http.StatusOK,
 			}
-			if err := enc.Encode(r); err != nil {
-				logger.Errorf("*** Encode: %v", err)
-				break loop
+			if result != nil {
+				r.BytesOut = result.SentBytes
 			}
+			if retErr != nil {
+				r.Code = http.StatusInternalServerError
+				r.Error = retErr.Error()
+			}
+			perTopic.Add(t.name, r)
+		}()
+
+		if err := inflight.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		defer inflight.Release(1)
+
+		d := t.gen.NewData()
+		seq = d.Seq
+		data, err := t.gen.Generate(d)
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := attrGen.Generate(d)
+		if err != nil {
+			return nil, err
+		}
+		msg := &pubsub.Message{
+			Data:        data,
+			Attributes:  attrs,
+			OrderingKey: keyGen.Generate(),
+		}
+		if *optE2EStamp {
+			msg.Attributes = e2e.Stamp{Run: t.run, Seq: d.Seq, SentAt: time.Now()}.Set(msg.Attributes)
+		}
+		if keyResumed, err := publish(ctx, t.topic, msg); err != nil {
+			atomic.AddInt64(&failed, 1)
+			if keyResumed {
+				logger.Warnf("Get: topic=%s, orderingKey=%s, %v", t.name, msg.OrderingKey, err)
+				atomic.AddInt64(&resumed, 1)
+			}
+			return nil, err
+		}
+		atomic.AddInt64(&gotID, 1)
+
+		return &vh.HitResult{
+			SentBytes: uint64(len(msg.Data)),
+			Code:      http.StatusOK,
+		}, nil
+	})
+	if runErr != nil {
+		logger.Errorf("*** Run: %v", runErr)
+	}
+
+	if len(targets) > 1 && *optLoadTest.Report != "none" {
+		if err := perTopic.Write(os.Stderr, *optLoadTest.Report); err != nil {
+			logger.Errorf("*** perTopic.Write: %v", err)
 		}
 	}
 
-	close(chRes)
-	logger.Infof("waiting goroutines for res.Get exit")
-	if err := eg.Wait(); err != nil {
-		logger.Errorf("Wait: %v", err)
+	logger.Infof("gotID=%d, failed=%d, resumed=%d", gotID, failed, resumed)
+	if *optE2EStamp {
+		for _, t := range targets {
+			logger.Infof("run=%s, maxSeq=%d, failed=%d", t.run, atomic.LoadInt64(&t.gen.seq), t.failed.Load())
+		}
 	}
-	logger.Infof("gotID=%d", gotID)
 
 	cancel()
+	if runErr != nil {
+		os.Exit(1)
+	}
 }
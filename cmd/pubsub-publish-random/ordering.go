@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// orderingKeyGenerator キー空間からordering keyを選ぶ
+type orderingKeyGenerator struct {
+	prefix string
+	next   func() uint64
+}
+
+// newOrderingKeyGenerator numKeysが0ならordering keyを付けない。distは uniform|zipf
+func newOrderingKeyGenerator(prefix string, numKeys uint64, dist string, zipfS, zipfV float64) (*orderingKeyGenerator, error) {
+	g := &orderingKeyGenerator{prefix: prefix}
+	if numKeys == 0 {
+		return g, nil
+	}
+
+	switch dist {
+	case "uniform":
+		g.next = func() uint64 { return rand.Uint64N(numKeys) }
+	case "zipf":
+		if zipfS <= 1 || zipfV < 1 {
+			return nil, fmt.Errorf("zipf requires s > 1 and v >= 1: s=%f, v=%f", zipfS, zipfV)
+		}
+		// rand.Zipfはgoroutine safeではない
+		var mu sync.Mutex
+		z := rand.NewZipf(rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())), zipfS, zipfV, numKeys-1)
+		g.next = func() uint64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return z.Uint64()
+		}
+	default:
+		return nil, fmt.Errorf("unknown ordering key distribution: %s", dist)
+	}
+	return g, nil
+}
+
+func (g *orderingKeyGenerator) Enabled() bool {
+	return g.next != nil
+}
+
+func (g *orderingKeyGenerator) Generate() string {
+	if g.next == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s%d", g.prefix, g.next())
+}
+
+// publish ackを待つ。ordering keyの付いたpublishが失敗したらそのkeyを再開してresumedをtrueにする
+func publish(ctx context.Context, topic *pubsub.Topic, msg *pubsub.Message) (resumed bool, err error) {
+	if _, err := topic.Publish(ctx, msg).Get(ctx); err != nil {
+		if msg.OrderingKey != "" && ctx.Err() == nil {
+			// ordering keyの付いたpublishが失敗するとそのkeyはResumePublishするまで受け付けられなくなる
+			topic.ResumePublish(msg.OrderingKey)
+			return true, err
+		}
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	pb "cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestOrderingKeyGenerator(t *testing.T) {
+	const n = 20000
+	tests := []struct {
+		name    string
+		keys    uint64
+		dist    string
+		check   func(t *testing.T, counts map[string]int)
+		wantErr string
+	}{
+		{
+			name: "uniform",
+			keys: 4,
+			dist: "uniform",
+			check: func(t *testing.T, counts map[string]int) {
+				for i := 0; i < 4; i++ {
+					// 期待値5000に対して十分広い幅
+					if c := counts[fmt.Sprintf("k-%d", i)]; c < 4000 || c > 6000 {
+						t.Errorf("k-%d=%d, want about %d", i, c, n/4)
+					}
+				}
+			},
+		},
+		{
+			name: "zipf",
+			keys: 100,
+			dist: "zipf",
+			check: func(t *testing.T, counts map[string]int) {
+				// s=2なら先頭のkeyが半分以上を占める
+				if c := counts["k-0"]; c < n/2 {
+					t.Errorf("k-0=%d, want > %d", c, n/2)
+				}
+				if counts["k-0"] <= counts["k-1"] || counts["k-1"] <= counts["k-10"] {
+					t.Errorf("k-0=%d, k-1=%d, k-10=%d, want decreasing", counts["k-0"], counts["k-1"], counts["k-10"])
+				}
+			},
+		},
+		{name: "single key", keys: 1, dist: "zipf", check: func(t *testing.T, counts map[string]int) {}},
+		{name: "unknown dist", keys: 4, dist: "normal", wantErr: "unknown ordering key distribution"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := newOrderingKeyGenerator("k-", tt.keys, tt.dist, 2, 1)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newOrderingKeyGenerator: %v", err)
+			}
+			if !g.Enabled() {
+				t.Fatalf("Enabled=false")
+			}
+			counts := map[string]int{}
+			for i := 0; i < n; i++ {
+				counts[g.Generate()]++
+			}
+			tt.check(t, counts)
+
+			// --ordering-keysの数を超えるkeyは出ない
+			for i := uint64(0); i < tt.keys; i++ {
+				delete(counts, fmt.Sprintf("k-%d", i))
+			}
+			if len(counts) != 0 {
+				t.Errorf("unexpected keys: %v", counts)
+			}
+		})
+	}
+}
+
+// TestOrderingKeyGeneratorAllKeys どの分布でも全てのkeyが使われる
+func TestOrderingKeyGeneratorAllKeys(t *testing.T) {
+	for _, dist := range []string{"uniform", "zipf"} {
+		g, err := newOrderingKeyGenerator("k-", 4, dist, 2, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := map[string]bool{}
+		for i := 0; i < 10000; i++ {
+			seen[g.Generate()] = true
+		}
+		if len(seen) != 4 {
+			t.Errorf("%s: keys=%v, want all 4 keys", dist, seen)
+		}
+	}
+}
+
+func TestOrderingKeyGeneratorDisabled(t *testing.T) {
+	g, err := newOrderingKeyGenerator("k-", 0, "zipf", 0, 0)
+	if err != nil {
+		t.Fatalf("newOrderingKeyGenerator: %v", err)
+	}
+	if g.Enabled() || g.Generate() != "" {
+		t.Errorf("Enabled=%t, Generate=%q, want no ordering key", g.Enabled(), g.Generate())
+	}
+
+	for _, tt := range []struct{ s, v float64 }{{1, 1}, {2, 0.5}} {
+		if _, err := newOrderingKeyGenerator("k-", 4, "zipf", tt.s, tt.v); err == nil || !strings.Contains(err.Error(), "zipf requires") {
+			t.Errorf("s=%f, v=%f: err=%v", tt.s, tt.v, err)
+		}
+	}
+}
+
+func TestPublishResumesOrderingKey(t *testing.T) {
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	defer srv.Close()
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cl, err := pubsub.NewClient(ctx, "pj", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	topic, err := cl.CreateTopic(ctx, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topic.Stop()
+	topic.EnableMessageOrdering = true
+	topic.PublishSettings.CountThreshold = 1
+
+	// InvalidArgumentはリトライされずにそのまま失敗する
+	srv.SetAutoPublishResponse(false)
+	srv.AddPublishResponse(nil, status.Error(codes.InvalidArgument, "rejected"))
+	resumed, err := publish(ctx, topic, &pubsub.Message{Data: []byte("1"), OrderingKey: "k"})
+	if status.Code(err) != codes.InvalidArgument || !resumed {
+		t.Fatalf("resumed=%t, err=%v, want resumed after InvalidArgument", resumed, err)
+	}
+
+	// 再開したkeyはまたpublishできる
+	srv.AddPublishResponse(&pb.PublishResponse{MessageIds: []string{"m2"}}, nil)
+	resumed, err = publish(ctx, topic, &pubsub.Message{Data: []byte("2"), OrderingKey: "k"})
+	if err != nil || resumed {
+		t.Fatalf("resumed=%t, err=%v, want published", resumed, err)
+	}
+
+	// ResumePublishしなければそのkeyは止まったまま
+	srv.AddPublishResponse(nil, status.Error(codes.InvalidArgument, "rejected"))
+	if _, err := topic.Publish(ctx, &pubsub.Message{Data: []byte("3"), OrderingKey: "k"}).Get(ctx); err == nil {
+		t.Fatal("Publish must fail")
+	}
+	_, err = topic.Publish(ctx, &pubsub.Message{Data: []byte("4"), OrderingKey: "k"}).Get(ctx)
+	var paused pubsub.ErrPublishingPaused
+	if !errors.As(err, &paused) {
+		t.Errorf("err=%v, want ErrPublishingPaused", err)
+	}
+
+	// ordering keyがなければ再開するものはない
+	srv.AddPublishResponse(nil, status.Error(codes.InvalidArgument, "rejected"))
+	resumed, err = publish(ctx, topic, &pubsub.Message{Data: []byte("5")})
+	if err == nil || resumed {
+		t.Errorf("resumed=%t, err=%v, want failed without resume", resumed, err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultPayloadTemplate = `hello: {{uuid}}`
+
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randString(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[rand.IntN(len(letters))]
+	}
+	return string(b)
+}
+
+func randInt(from, to int) int {
+	if to <= from {
+		return from
+	}
+	return from + rand.IntN(to-from+1)
+}
+
+func randFloat(from, to float64) float64 {
+	return from + rand.Float64()*(to-from)
+}
+
+func randChoice(v ...string) string {
+	if len(v) == 0 {
+		return ""
+	}
+	return v[rand.IntN(len(v))]
+}
+
+var templateFuncs = template.FuncMap{
+	"uuid":       func() string { return uuid.New().String() },
+	"now":        time.Now,
+	"unixMilli":  func() int64 { return time.Now().UnixMilli() },
+	"randString": randString,
+	"randInt":    randInt,
+	"randFloat":  randFloat,
+	"randChoice": randChoice,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// templateData テンプレートに渡す値
+type templateData struct {
+	// Seq 1から始まる通し番号
+	Seq int64
+	// Size サイズ分布から得たメッセージサイズ。分布指定がなければ0
+	Size int
+	Now  time.Time
+}
+
+// sizeDist メッセージサイズの分布
+type sizeDist func() int
+
+// parseSizeDist fixed:N | uniform:MIN-MAX | normal:MEAN,STDDEV | exp:MEAN。空ならnil
+func parseSizeDist(s string) (sizeDist, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	kind, arg, _ := strings.Cut(s, ":")
+	parseInts := func(sep string, n int) ([]int, error) {
+		fields := strings.Split(arg, sep)
+		if len(fields) != n {
+			return nil, fmt.Errorf("invalid size distribution: %s", s)
+		}
+		ret := make([]int, 0, n)
+		for _, e := range fields {
+			v, err := strconv.Atoi(strings.TrimSpace(e))
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid size distribution: %s", s)
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	}
+
+	switch kind {
+	case "fixed":
+		v, err := parseInts(",", 1)
+		if err != nil {
+			return nil, err
+		}
+		return func() int { return v[0] }, nil
+	case "uniform":
+		v, err := parseInts("-", 2)
+		if err != nil {
+			return nil, err
+		}
+		if v[0] > v[1] {
+			return nil, fmt.Errorf("MIN must be <= MAX: %s", s)
+		}
+		return func() int { return randInt(v[0], v[1]) }, nil
+	case "normal":
+		v, err := parseInts(",", 2)
+		if err != nil {
+			return nil, err
+		}
+		return func() int {
+			return max(0, int(math.Round(rand.NormFloat64()*float64(v[1])+float64(v[0]))))
+		}, nil
+	case "exp":
+		v, err := parseInts(",", 1)
+		if err != nil {
+			return nil, err
+		}
+		return func() int { return int(math.Round(rand.ExpFloat64() * float64(v[0]))) }, nil
+	default:
+		return nil, fmt.Errorf("unknown size distribution: %s", s)
+	}
+}
+
+type payloadGenerator struct {
+	tmpl *template.Template
+	// size nilでなければpayloadをこのサイズに合わせる
+	size   sizeDist
+	isJSON bool
+	seq    int64
+	// enc スキーマの付いたtopic向け。nilでなければスキーマに沿ってエンコードする
+	enc recordEncoder
+	// fromTemplate trueならテンプレートで作ったJSONをencでエンコードし直す。falseならランダムなレコードを作る
+	fromTemplate bool
+}
+
+// newPayloadGenerator tmplTextが空ならデフォルトのテンプレートを使う。formatは text|json
+func newPayloadGenerator(tmplText string, format string, size string) (*payloadGenerator, error) {
+	if tmplText == "" {
+		tmplText = defaultPayloadTemplate
+	}
+
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Parse(tmplText)
+	if err != nil {
+		return nil, fmt.Errorf("template.Parse: %w", err)
+	}
+
+	dist, err := parseSizeDist(size)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &payloadGenerator{
+		tmpl: tmpl,
+		size: dist,
+	}
+	switch format {
+	case "text":
+	case "json":
+		g.isJSON = true
+	default:
+		return nil, fmt.Errorf("unknown payload format: %s", format)
+	}
+
+	return g, nil
+}
+
+// loadTemplate パスが空なら空文字列を返す
+func loadTemplate(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("os.ReadFile: %w", err)
+	}
+	return string(b), nil
+}
+
+// NewData 1メッセージ分のテンプレートに渡す値を作る。payloadと属性で同じ値を使う
+func (g *payloadGenerator) NewData() templateData {
+	d := templateData{
+		Seq: atomic.AddInt64(&g.seq, 1),
+		Now: time.Now(),
+	}
+	if g.size != nil {
+		d.Size = g.size()
+	}
+	return d
+}
+
+// SetRecordEncoder スキーマの付いたtopic向けにする。サイズは合わせなくなる
+func (g *payloadGenerator) SetRecordEncoder(enc recordEncoder, fromTemplate bool) {
+	g.enc = enc
+	g.fromTemplate = fromTemplate
+}
+
+func (g *payloadGenerator) Generate(d templateData) ([]byte, error) {
+	if g.enc != nil && !g.fromTemplate {
+		return g.enc.Random()
+	}
+
+	var buf bytes.Buffer
+	if err := g.tmpl.Execute(&buf, d); err != nil {
+		return nil, fmt.Errorf("tmpl.Execute: %w", err)
+	}
+
+	b := buf.Bytes()
+	if g.enc != nil {
+		return g.enc.FromJSON(b)
+	}
+
+	if g.isJSON {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, b); err != nil {
+			return nil, fmt.Errorf("json.Compact: %w", err)
+		}
+		b = compacted.Bytes()
+	}
+
+	if g.size != nil {
+		b = g.fitTo(b, d.Size)
+	}
+
+	return b, nil
+}
+
+// padFieldOverhead 空の"_pad"フィールドの長さ
+const padFieldOverhead = len(`"_pad":""`)
+
+// fitTo textは空白で埋めるか切り詰めてちょうどsizeにする。
+// JSONは有効なままにするため切り詰めず、オブジェクトなら"_pad"フィールドで、
+// フィールドが収まらないかオブジェクトでなければ末尾の空白で埋める
+func (g *payloadGenerator) fitTo(b []byte, size int) []byte {
+	if len(b) >= size {
+		if g.isJSON {
+			return b
+		}
+		return b[:size]
+	}
+
+	n := size - len(b)
+	ret := make([]byte, 0, size)
+	if g.isJSON && len(b) >= 2 && b[len(b)-1] == '}' {
+		overhead := padFieldOverhead
+		if !bytes.Equal(b, []byte("{}")) {
+			overhead++
+		}
+		if n >= overhead {
+			ret = append(ret, b[:len(b)-1]...)
+			if overhead > padFieldOverhead {
+				ret = append(ret, ',')
+			}
+			ret = append(ret, `"_pad":"`...)
+			ret = append(ret, strings.Repeat(" ", n-overhead)...)
+			return append(ret, `"}`...)
+		}
+	}
+	ret = append(ret, b...)
+	return append(ret, strings.Repeat(" ", n)...)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		tmpl   string
+		format string
+		size   string
+		// lo, hi Sizeの取りうる範囲
+		lo, hi int
+	}{
+		{name: "text fixed", tmpl: "hello {{.Seq}}", format: "text", size: "fixed:100", lo: 100, hi: 100},
+		{name: "text fixed truncate", tmpl: "{{randString 50}}", format: "text", size: "fixed:10", lo: 10, hi: 10},
+		{name: "text fixed 0", tmpl: "hello", format: "text", size: "fixed:0", lo: 0, hi: 0},
+		{name: "text uniform", tmpl: "", format: "text", size: "uniform:10-60", lo: 10, hi: 60},
+		{name: "text exp", tmpl: "", format: "text", size: "exp:40", lo: 0, hi: -1},
+		{name: "text normal", tmpl: "", format: "text", size: "normal:40,10", lo: 0, hi: -1},
+		{name: "text uses size", tmpl: "{{randString .Size}}", format: "text", size: "uniform:0-20", lo: 0, hi: 20},
+		{name: "json fixed", tmpl: `{"seq": {{.Seq}}}`, format: "json", size: "fixed:100", lo: 100, hi: 100},
+		{name: "json empty object", tmpl: `{}`, format: "json", size: "fixed:20", lo: 20, hi: 20},
+		{name: "json uniform", tmpl: `{"a": "{{uuid}}"}`, format: "json", size: "uniform:0-100", lo: 0, hi: 100},
+		{name: "json exp", tmpl: `{"a": 1}`, format: "json", size: "exp:30", lo: 0, hi: -1},
+		{name: "json array", tmpl: `[1, 2]`, format: "json", size: "uniform:0-30", lo: 0, hi: 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := newPayloadGenerator(tt.tmpl, tt.format, tt.size)
+			if err != nil {
+				t.Fatalf("newPayloadGenerator: %v", err)
+			}
+			for i := 0; i < 200; i++ {
+				d := g.NewData()
+				if d.Size < tt.lo || (tt.hi >= 0 && d.Size > tt.hi) {
+					t.Fatalf("Size=%d, want %d..%d", d.Size, tt.lo, tt.hi)
+				}
+				b, err := g.Generate(d)
+				if err != nil {
+					t.Fatalf("Generate: %v", err)
+				}
+				if tt.format == "text" {
+					if len(b) != d.Size {
+						t.Fatalf("len=%d, want %d: %q", len(b), d.Size, b)
+					}
+					continue
+				}
+
+				if !json.Valid(b) {
+					t.Fatalf("invalid JSON: %q", b)
+				}
+				// JSONは切り詰めないのでテンプレートの出力より小さいサイズには合わせられない
+				rendered, err := (&payloadGenerator{tmpl: g.tmpl, isJSON: true}).Generate(d)
+				if err != nil {
+					t.Fatalf("Generate: %v", err)
+				}
+				if want := max(d.Size, len(rendered)); len(b) != want {
+					t.Fatalf("len=%d, want %d: %q", len(b), want, b)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateJSONPad(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		size int
+		want string
+	}{
+		{tmpl: `{"a":1}`, size: 7, want: `{"a":1}`},
+		{tmpl: `{"a":1}`, size: 3, want: `{"a":1}`},
+		// ,"_pad":""の10バイトが入らなければ末尾の空白で埋める
+		{tmpl: `{"a":1}`, size: 16, want: `{"a":1}         `},
+		{tmpl: `{"a":1}`, size: 17, want: `{"a":1,"_pad":""}`},
+		{tmpl: `{"a":1}`, size: 20, want: `{"a":1,"_pad":"   "}`},
+		{tmpl: `{ }`, size: 11, want: `{"_pad":""}`},
+		{tmpl: `"s"`, size: 5, want: `"s"  `},
+	}
+	for _, tt := range tests {
+		g, err := newPayloadGenerator(tt.tmpl, "json", "fixed:1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := g.Generate(templateData{Size: tt.size})
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s, size=%d: got=%q, want %q", tt.tmpl, tt.size, b, tt.want)
+		}
+	}
+}
+
+func TestGenerateWithoutSize(t *testing.T) {
+	g, err := newPayloadGenerator(`{ "seq" : {{.Seq}}, "size": {{.Size}} }`, "json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := g.Generate(g.NewData())
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if string(b) != `{"seq":1,"size":0}` {
+		t.Errorf("got=%s", b)
+	}
+}
+
+func TestPayloadGeneratorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		format  string
+		size    string
+		wantErr string
+		// genErr Generateで失敗する
+		genErr bool
+	}{
+		{name: "parse", tmpl: "{{.Seq", format: "text", wantErr: "template.Parse"},
+		{name: "unknown func", tmpl: "{{nosuch}}", format: "text", wantErr: "template.Parse"},
+		{name: "format", format: "xml", wantErr: "unknown payload format: xml"},
+		{name: "size kind", format: "text", size: "pareto:1", wantErr: "unknown size distribution"},
+		{name: "size args", format: "text", size: "uniform:10", wantErr: "invalid size distribution"},
+		{name: "size negative", format: "text", size: "fixed:-1", wantErr: "invalid size distribution"},
+		{name: "size range", format: "text", size: "uniform:10-5", wantErr: "MIN must be <= MAX"},
+		{name: "execute", tmpl: "{{.Nothing}}", format: "text", wantErr: "tmpl.Execute", genErr: true},
+		{name: "func args", tmpl: "{{randInt 1}}", format: "text", wantErr: "tmpl.Execute", genErr: true},
+		{name: "invalid json", tmpl: `{"a": {{.Seq}}`, format: "json", size: "fixed:100", wantErr: "json.Compact", genErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := newPayloadGenerator(tt.tmpl, tt.format, tt.size)
+			if tt.genErr {
+				if err != nil {
+					t.Fatalf("newPayloadGenerator: %v", err)
+				}
+				_, err = g.Generate(g.NewData())
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
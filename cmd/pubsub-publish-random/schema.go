@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+)
+
+// recordEncoder スキーマの付いたtopicに送れるレコードを作る
+type recordEncoder interface {
+	// Random スキーマに沿ったランダムなレコードをエンコードして返す
+	Random() ([]byte, error)
+	// FromJSON テンプレートから作ったJSONをスキーマに沿って解釈し、エンコードし直す
+	FromJSON(b []byte) ([]byte, error)
+}
+
+type schemaSpec struct {
+	name       string
+	typ        pubsub.SchemaType
+	definition string
+	encoding   pubsub.SchemaEncoding
+}
+
+func parseSchemaEncoding(s string) (pubsub.SchemaEncoding, error) {
+	switch s {
+	case "json":
+		return pubsub.EncodingJSON, nil
+	case "binary":
+		return pubsub.EncodingBinary, nil
+	default:
+		return 0, fmt.Errorf("unknown schema encoding: %s", s)
+	}
+}
+
+// loadSchemaFile typは avro|protobuf
+func loadSchemaFile(path string, typ string, encoding string) (*schemaSpec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	spec := &schemaSpec{name: path, definition: string(b)}
+	switch typ {
+	case "avro":
+		spec.typ = pubsub.SchemaAvro
+	case "protobuf":
+		spec.typ = pubsub.SchemaProtocolBuffer
+	default:
+		return nil, fmt.Errorf("unknown schema type: %s", typ)
+	}
+
+	spec.encoding, err = parseSchemaEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// topicSchema topicにスキーマが付いていなければnilを返す。
+// topicがLastRevisionIDで受け付けるリビジョンを絞っていればそのリビジョンを、そうでなければ最新のリビジョンを使う
+func topicSchema(ctx context.Context, topic *pubsub.Topic, opts ...option.ClientOption) (*schemaSpec, error) {
+	cfg, err := topic.Config(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("topic.Config: %w", err)
+	}
+	if cfg.SchemaSettings == nil || cfg.SchemaSettings.Schema == "" {
+		return nil, nil
+	}
+
+	// projects/{project}/schemas/{schema}
+	fields := strings.Split(cfg.SchemaSettings.Schema, "/")
+	if len(fields) != 4 || fields[0] != "projects" || fields[2] != "schemas" {
+		return nil, fmt.Errorf("unexpected schema name: %s", cfg.SchemaSettings.Schema)
+	}
+
+	sc, err := pubsub.NewSchemaClient(ctx, fields[1], opts...)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub.NewSchemaClient: %w", err)
+	}
+	defer sc.Close()
+
+	id := fields[3]
+	if rev := cfg.SchemaSettings.LastRevisionID; rev != "" {
+		id += "@" + rev
+	}
+	s, err := sc.Schema(ctx, id, pubsub.SchemaViewFull)
+	if err != nil {
+		return nil, fmt.Errorf("Schema: %s, %w", id, err)
+	}
+
+	return &schemaSpec{
+		name:       s.Name + "@" + s.RevisionID,
+		typ:        s.Type,
+		definition: s.Definition,
+		encoding:   cfg.SchemaSettings.Encoding,
+	}, nil
+}
+
+func newRecordEncoder(spec *schemaSpec) (recordEncoder, error) {
+	if spec.encoding != pubsub.EncodingJSON && spec.encoding != pubsub.EncodingBinary {
+		return nil, fmt.Errorf("unsupported schema encoding: %v", spec.encoding)
+	}
+
+	switch spec.typ {
+	case pubsub.SchemaAvro:
+		return newAvroEncoder(spec.definition, spec.encoding == pubsub.EncodingBinary)
+	case pubsub.SchemaProtocolBuffer:
+		return newProtoEncoder(spec.definition, spec.encoding == pubsub.EncodingBinary)
+	default:
+		return nil, fmt.Errorf("unsupported schema type: %v", spec.typ)
+	}
+}
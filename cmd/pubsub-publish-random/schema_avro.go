@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// maxRecordDepth 再帰する型で無限にネストしないための上限
+const maxRecordDepth = 3
+
+// goavroがnativeとしてtime.Timeなどを扱う論理型
+var avroLogicalTypes = map[string]bool{
+	"timestamp-millis": true,
+	"timestamp-micros": true,
+	"time-millis":      true,
+	"time-micros":      true,
+	"date":             true,
+	"decimal":          true,
+}
+
+var _ recordEncoder = (*avroEncoder)(nil)
+
+type avroEncoder struct {
+	codec  *goavro.Codec
+	schema interface{}
+	binary bool
+	// names 名前付きの型(record, enum, fixed)のフルネームと定義
+	names map[string]map[string]interface{}
+}
+
+func newAvroEncoder(definition string, binary bool) (*avroEncoder, error) {
+	codec, err := goavro.NewCodec(definition)
+	if err != nil {
+		return nil, fmt.Errorf("goavro.NewCodec: %w", err)
+	}
+
+	var schema interface{}
+	if err := json.Unmarshal([]byte(definition), &schema); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	e := &avroEncoder{
+		codec:  codec,
+		schema: schema,
+		binary: binary,
+		names:  map[string]map[string]interface{}{},
+	}
+	e.collectNames(schema, "")
+	return e, nil
+}
+
+func fullName(name, ns string) string {
+	if strings.Contains(name, ".") || ns == "" {
+		return name
+	}
+	return ns + "." + name
+}
+
+// namespaceOf 名前付きの型の中で使われる名前空間
+func namespaceOf(s map[string]interface{}, ns string) string {
+	name, _ := s["name"].(string)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	if v, ok := s["namespace"].(string); ok {
+		return v
+	}
+	return ns
+}
+
+func (e *avroEncoder) collectNames(s interface{}, ns string) {
+	switch s := s.(type) {
+	case []interface{}:
+		for _, b := range s {
+			e.collectNames(b, ns)
+		}
+	case map[string]interface{}:
+		switch t := s["type"].(type) {
+		case string:
+			switch t {
+			case "record", "error", "enum", "fixed":
+				name, _ := s["name"].(string)
+				childNS := namespaceOf(s, ns)
+				e.names[fullName(name, childNS)] = s
+				if fields, ok := s["fields"].([]interface{}); ok {
+					for _, f := range fields {
+						if fm, ok := f.(map[string]interface{}); ok {
+							e.collectNames(fm["type"], childNS)
+						}
+					}
+				}
+			case "array":
+				e.collectNames(s["items"], ns)
+			case "map":
+				e.collectNames(s["values"], ns)
+			}
+		default:
+			e.collectNames(t, ns)
+		}
+	}
+}
+
+func (e *avroEncoder) encode(native interface{}) ([]byte, error) {
+	if e.binary {
+		b, err := e.codec.BinaryFromNative(nil, native)
+		if err != nil {
+			return nil, fmt.Errorf("BinaryFromNative: %w", err)
+		}
+		return b, nil
+	}
+	b, err := e.codec.TextualFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("TextualFromNative: %w", err)
+	}
+	return b, nil
+}
+
+func (e *avroEncoder) Random() ([]byte, error) {
+	return e.encode(e.random(e.schema, "", 0))
+}
+
+func (e *avroEncoder) FromJSON(b []byte) ([]byte, error) {
+	native, _, err := e.codec.NativeFromTextual(b)
+	if err != nil {
+		return nil, fmt.Errorf("NativeFromTextual: %w", err)
+	}
+	return e.encode(native)
+}
+
+// unionBranchName goavroがunionのnativeのキーとして使う名前
+func (e *avroEncoder) unionBranchName(s interface{}, ns string) string {
+	switch s := s.(type) {
+	case string:
+		if _, ok := e.names[fullName(s, ns)]; ok {
+			return fullName(s, ns)
+		}
+		return s
+	case map[string]interface{}:
+		t, _ := s["type"].(string)
+		switch t {
+		case "record", "error", "enum", "fixed":
+			name, _ := s["name"].(string)
+			return fullName(name, namespaceOf(s, ns))
+		}
+		if lt, ok := s["logicalType"].(string); ok && avroLogicalTypes[lt] {
+			return t + "." + lt
+		}
+		return e.unionBranchName(s["type"], ns)
+	}
+	return ""
+}
+
+func (e *avroEncoder) random(s interface{}, ns string, depth int) interface{} {
+	switch s := s.(type) {
+	case string:
+		return e.randomPrimitive(s, ns, depth)
+	case []interface{}:
+		if len(s) == 0 {
+			return nil
+		}
+		b := s[rand.IntN(len(s))]
+		if depth >= maxRecordDepth {
+			// 深くなったらnullがあればnullにして止める
+			for _, m := range s {
+				if m == "null" {
+					b = m
+				}
+			}
+		}
+		if b == "null" {
+			return nil
+		}
+		return goavro.Union(e.unionBranchName(b, ns), e.random(b, ns, depth))
+	case map[string]interface{}:
+		if lt, ok := s["logicalType"].(string); ok && avroLogicalTypes[lt] {
+			return randomAvroLogical(lt, s)
+		}
+
+		t, ok := s["type"].(string)
+		if !ok {
+			return e.random(s["type"], ns, depth)
+		}
+		switch t {
+		case "record", "error":
+			childNS := namespaceOf(s, ns)
+			fields, _ := s["fields"].([]interface{})
+			rec := make(map[string]interface{}, len(fields))
+			for _, f := range fields {
+				fm, ok := f.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := fm["name"].(string)
+				rec[name] = e.random(fm["type"], childNS, depth+1)
+			}
+			return rec
+		case "enum":
+			symbols, _ := s["symbols"].([]interface{})
+			if len(symbols) == 0 {
+				return ""
+			}
+			return symbols[rand.IntN(len(symbols))]
+		case "array":
+			n := rand.IntN(4)
+			if depth >= maxRecordDepth {
+				n = 0
+			}
+			ret := make([]interface{}, 0, n)
+			for i := 0; i < n; i++ {
+				ret = append(ret, e.random(s["items"], ns, depth+1))
+			}
+			return ret
+		case "map":
+			n := rand.IntN(4)
+			if depth >= maxRecordDepth {
+				n = 0
+			}
+			ret := make(map[string]interface{}, n)
+			for i := 0; i < n; i++ {
+				ret[randString(8)] = e.random(s["values"], ns, depth+1)
+			}
+			return ret
+		case "fixed":
+			size, _ := s["size"].(float64)
+			return randomBytes(int(size))
+		default:
+			return e.randomPrimitive(t, ns, depth)
+		}
+	}
+	return nil
+}
+
+func (e *avroEncoder) randomPrimitive(t string, ns string, depth int) interface{} {
+	switch t {
+	case "null":
+		return nil
+	case "boolean":
+		return rand.IntN(2) == 1
+	case "int":
+		return rand.Int32()
+	case "long":
+		return rand.Int64()
+	case "float":
+		return rand.Float32()
+	case "double":
+		return rand.Float64()
+	case "bytes":
+		return randomBytes(rand.IntN(16))
+	case "string":
+		return randString(rand.IntN(16) + 1)
+	}
+	if s, ok := e.names[fullName(t, ns)]; ok {
+		return e.random(s, ns, depth)
+	}
+	return nil
+}
+
+func randomAvroLogical(lt string, s map[string]interface{}) interface{} {
+	switch lt {
+	case "timestamp-millis", "timestamp-micros", "date":
+		return time.Now().UTC().Add(-time.Duration(rand.Int64N(int64(365 * 24 * time.Hour))))
+	case "time-millis", "time-micros":
+		return time.Duration(rand.Int64N(int64(24 * time.Hour))).Truncate(time.Millisecond)
+	case "decimal":
+		scale, _ := s["scale"].(float64)
+		precision, _ := s["precision"].(float64)
+		digits := max(1, int(precision)-int(scale))
+		n := big.NewInt(rand.Int64N(int64(min(1e15, math.Pow10(digits)))))
+		return new(big.Rat).SetFrac(n, big.NewInt(1))
+	}
+	return nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(rand.IntN(256))
+	}
+	return b
+}
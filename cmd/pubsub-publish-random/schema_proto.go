@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var _ recordEncoder = (*protoEncoder)(nil)
+
+type protoEncoder struct {
+	desc   protoreflect.MessageDescriptor
+	binary bool
+}
+
+// newProtoEncoder Pub/Subのprotobufスキーマは1ファイルで、最初のメッセージ型がレコードの型になる
+func newProtoEncoder(definition string, binary bool) (*protoEncoder, error) {
+	const fileName = "schema.proto"
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{fileName: definition}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Compile: %w", err)
+	}
+
+	msgs := files[0].Messages()
+	if msgs.Len() == 0 {
+		return nil, fmt.Errorf("no message type in schema")
+	}
+
+	return &protoEncoder{
+		desc:   msgs.Get(0),
+		binary: binary,
+	}, nil
+}
+
+func (e *protoEncoder) encode(msg proto.Message) ([]byte, error) {
+	if e.binary {
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("proto.Marshal: %w", err)
+		}
+		return b, nil
+	}
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("protojson.Marshal: %w", err)
+	}
+	return b, nil
+}
+
+func (e *protoEncoder) Random() ([]byte, error) {
+	msg := dynamicpb.NewMessage(e.desc)
+	fillRandomMessage(msg, 0)
+	return e.encode(msg)
+}
+
+func (e *protoEncoder) FromJSON(b []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(e.desc)
+	if err := protojson.Unmarshal(b, msg); err != nil {
+		return nil, fmt.Errorf("protojson.Unmarshal: %w", err)
+	}
+	return e.encode(msg)
+}
+
+// maxRequiredDepth proto2のrequiredが循環していると有限のメッセージは作れないので、そのときにスタックを溢れさせないための上限
+const maxRequiredDepth = maxRecordDepth * 4
+
+// isMessageField メッセージ型の単数フィールド
+func isMessageField(fd protoreflect.FieldDescriptor) bool {
+	return !fd.IsList() && !fd.IsMap() && (fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind)
+}
+
+func fillRandomMessage(msg protoreflect.Message, depth int) {
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if oo := fd.ContainingOneof(); oo != nil && !oo.IsSynthetic() {
+			// oneofは最初のフィールドのところで1つ選んで埋める
+			if oo.Fields().Get(0) == fd {
+				fd := oo.Fields().Get(rand.IntN(oo.Fields().Len()))
+				if !isMessageField(fd) || depth < maxRecordDepth {
+					msg.Set(fd, randomProtoValue(msg, fd, depth))
+				}
+			}
+			continue
+		}
+
+		switch {
+		case fd.IsList():
+			if depth >= maxRecordDepth {
+				continue
+			}
+			l := msg.Mutable(fd).List()
+			for n := rand.IntN(4); n > 0; n-- {
+				if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+					v := l.NewElement()
+					fillRandomMessage(v.Message(), depth+1)
+					l.Append(v)
+				} else {
+					l.Append(randomProtoScalar(fd))
+				}
+			}
+		case fd.IsMap():
+			if depth >= maxRecordDepth {
+				continue
+			}
+			m := msg.Mutable(fd).Map()
+			for n := rand.IntN(4); n > 0; n-- {
+				k := randomProtoScalar(fd.MapKey()).MapKey()
+				if fd.MapValue().Kind() == protoreflect.MessageKind {
+					v := m.NewValue()
+					fillRandomMessage(v.Message(), depth+1)
+					m.Set(k, v)
+				} else {
+					m.Set(k, randomProtoScalar(fd.MapValue()))
+				}
+			}
+		default:
+			if isMessageField(fd) && depth >= maxRecordDepth {
+				// requiredは深さの上限を過ぎても埋めないとエンコードできない
+				if fd.Cardinality() != protoreflect.Required || depth >= maxRequiredDepth {
+					continue
+				}
+			}
+			msg.Set(fd, randomProtoValue(msg, fd, depth))
+		}
+	}
+}
+
+func randomProtoValue(msg protoreflect.Message, fd protoreflect.FieldDescriptor, depth int) protoreflect.Value {
+	if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		v := msg.NewField(fd)
+		fillRandomMessage(v.Message(), depth+1)
+		return v
+	}
+	return randomProtoScalar(fd)
+}
+
+func randomProtoScalar(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(rand.IntN(2) == 1)
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		return protoreflect.ValueOfEnum(values.Get(rand.IntN(values.Len())).Number())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(rand.Int32())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(rand.Uint32())
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(rand.Int64())
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(rand.Uint64())
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(rand.Float32())
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(rand.Float64())
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(randString(rand.IntN(16) + 1))
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes(randomBytes(rand.IntN(16)))
+	}
+	return fd.Default()
+}
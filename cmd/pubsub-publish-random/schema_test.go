@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testAvroSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "example",
+  "fields": [
+    {"name": "id", "type": "string"},
+    {"name": "count", "type": "long"},
+    {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}},
+    {"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 4}},
+    {"name": "at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "attrs", "type": {"type": "map", "values": "Kind"}},
+    {"name": "note", "type": ["null", "string"]},
+    {"name": "parent", "type": ["null", "Event"]}
+  ]
+}`
+
+func TestAvroEncoderRoundTrip(t *testing.T) {
+	codec, err := goavro.NewCodec(testAvroSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, binary := range []bool{false, true} {
+		e, err := newAvroEncoder(testAvroSchema, binary)
+		if err != nil {
+			t.Fatalf("newAvroEncoder: %v", err)
+		}
+		for i := 0; i < 100; i++ {
+			b, err := e.Random()
+			if err != nil {
+				t.Fatalf("binary=%t, Random: %v", binary, err)
+			}
+			var rest []byte
+			if binary {
+				_, rest, err = codec.NativeFromBinary(b)
+			} else {
+				_, rest, err = codec.NativeFromTextual(b)
+			}
+			if err != nil {
+				t.Fatalf("binary=%t, decode: %v, %q", binary, err, b)
+			}
+			if len(rest) != 0 {
+				t.Fatalf("binary=%t, %d bytes left", binary, len(rest))
+			}
+		}
+	}
+
+	e, err := newAvroEncoder(testAvroSchema, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := `{"id": "x", "count": 1, "kind": "B", "hash": "abcd", "at": 0, "tags": [], "attrs": {}, "note": {"string": "n"}, "parent": null}`
+	b, err := e.FromJSON([]byte(text))
+	if err != nil {
+		t.Fatalf("FromJSON: %v", err)
+	}
+	native, _, err := codec.NativeFromBinary(b)
+	if err != nil {
+		t.Fatalf("NativeFromBinary: %v", err)
+	}
+	if rec := native.(map[string]interface{}); rec["id"] != "x" || rec["kind"] != "B" {
+		t.Errorf("record=%v", rec)
+	}
+}
+
+const testProtoSchema = `syntax = "proto2";
+
+message Node {
+  required string id = 1;
+  required Leaf leaf = 2;
+  optional Node next = 3;
+  repeated Node children = 4;
+  map<string, Leaf> leaves = 5;
+  oneof value {
+    int64 number = 6;
+    Node other = 7;
+  }
+  required Chain chain = 8;
+}
+
+message Leaf {
+  required int32 n = 1;
+  optional bytes data = 2;
+  enum Color {
+    RED = 0;
+    BLUE = 1;
+  }
+  required Color color = 3;
+}
+
+// 深さの上限を過ぎてもrequiredをたどって埋める必要がある
+message Chain {
+  required Chain2 c = 1;
+}
+
+message Chain2 {
+  required Leaf leaf = 1;
+}
+`
+
+func TestProtoEncoderRoundTrip(t *testing.T) {
+	for _, binary := range []bool{false, true} {
+		e, err := newProtoEncoder(testProtoSchema, binary)
+		if err != nil {
+			t.Fatalf("newProtoEncoder: %v", err)
+		}
+		for i := 0; i < 100; i++ {
+			b, err := e.Random()
+			if err != nil {
+				t.Fatalf("binary=%t, Random: %v", binary, err)
+			}
+			msg := dynamicpb.NewMessage(e.desc)
+			if binary {
+				err = proto.Unmarshal(b, msg)
+			} else {
+				err = protojson.Unmarshal(b, msg)
+			}
+			if err != nil {
+				t.Fatalf("binary=%t, Unmarshal: %v", binary, err)
+			}
+			if err := proto.CheckInitialized(msg); err != nil {
+				t.Fatalf("binary=%t, CheckInitialized: %v", binary, err)
+			}
+		}
+	}
+}
+
+func TestFillRandomMessageRequiredPastDepth(t *testing.T) {
+	e, err := newProtoEncoder(testProtoSchema, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 深さの上限から埋め始めても、requiredのメッセージはたどって埋める
+	msg := dynamicpb.NewMessage(e.desc)
+	fillRandomMessage(msg, maxRecordDepth)
+	if err := proto.CheckInitialized(msg); err != nil {
+		t.Fatalf("CheckInitialized: %v", err)
+	}
+	fields := e.desc.Fields()
+	for _, name := range []protoreflect.Name{"next", "children", "leaves"} {
+		if msg.Has(fields.ByName(name)) {
+			t.Errorf("%s must not be filled past the depth limit", name)
+		}
+	}
+}
+
+func TestTopicSchemaRevision(t *testing.T) {
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	defer srv.Close()
+	// topicSchemaがSchemaClientをCloseするので、接続は共有せずにクライアントごとに張る
+	opts := []option.ClientOption{
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+
+	cl, err := pubsub.NewClient(ctx, "pj", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	sc, err := pubsub.NewSchemaClient(ctx, "pj", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	v1 := `{"type": "record", "name": "R", "fields": [{"name": "a", "type": "string"}]}`
+	v2 := `{"type": "record", "name": "R", "fields": [{"name": "b", "type": "long"}]}`
+	first, err := sc.CreateSchema(ctx, "s", pubsub.SchemaConfig{Type: pubsub.SchemaAvro, Definition: v1})
+	if err != nil {
+		t.Fatalf("CreateSchema: %v", err)
+	}
+	if _, err := sc.CommitSchema(ctx, "s", pubsub.SchemaConfig{Name: first.Name, Type: pubsub.SchemaAvro, Definition: v2}); err != nil {
+		t.Fatalf("CommitSchema: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		settings *pubsub.SchemaSettings
+		want     string
+	}{
+		{name: "no schema", settings: nil},
+		{name: "latest", settings: &pubsub.SchemaSettings{Schema: first.Name, Encoding: pubsub.EncodingJSON}, want: v2},
+		{
+			name:     "last revision",
+			settings: &pubsub.SchemaSettings{Schema: first.Name, Encoding: pubsub.EncodingBinary, LastRevisionID: first.RevisionID},
+			want:     v1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic, err := cl.CreateTopicWithConfig(ctx, strings.ReplaceAll(tt.name, " ", "-"), &pubsub.TopicConfig{SchemaSettings: tt.settings})
+			if err != nil {
+				t.Fatalf("CreateTopicWithConfig: %v", err)
+			}
+			spec, err := topicSchema(ctx, topic, opts...)
+			if err != nil {
+				t.Fatalf("topicSchema: %v", err)
+			}
+			if tt.settings == nil {
+				if spec != nil {
+					t.Errorf("spec=%v, want nil", spec)
+				}
+				return
+			}
+			if spec.definition != tt.want || spec.encoding != tt.settings.Encoding {
+				t.Errorf("definition=%s, encoding=%v, want %s, %v", spec.definition, spec.encoding, tt.want, tt.settings.Encoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// topicSpec --topicsで指定するファイルの要素。payload関連で省略したものはフラグの値を使う
+type topicSpec struct {
+	Name            string `json:"name"`
+	Weight          int    `json:"weight"`
+	PayloadTemplate string `json:"payloadTemplate"`
+	PayloadFormat   string `json:"payloadFormat"`
+	PayloadSize     string `json:"payloadSize"`
+}
+
+// loadTopicSpecs JSONの配列。エラーには要素の始まる行番号を付ける
+func loadTopicSpecs(path string) ([]topicSpec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	} else if t != json.Delim('[') {
+		return nil, fmt.Errorf("%s: must be a JSON array", path)
+	}
+
+	var specs []topicSpec
+	// lines 名前ごとに最初に現れた行
+	lines := map[string]int{}
+	for dec.More() {
+		ln := lineAt(b, dec.InputOffset())
+		var e topicSpec
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, ln, err)
+		}
+		if e.Name == "" {
+			return nil, fmt.Errorf("%s:%d: name must be specified", path, ln)
+		}
+		if e.Weight < 0 {
+			return nil, fmt.Errorf("%s:%d: weight must be >= 0", path, ln)
+		}
+		// 同じtopicが複数あるとrunIDもseqも共有しないpublisherが並んでe2eの欠番や重複に見えてしまう
+		if first, ok := lines[e.Name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate topic %q, first at line %d", path, ln, e.Name, first)
+		}
+		lines[e.Name] = ln
+		specs = append(specs, e)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("%s: no topics", path)
+	}
+	return specs, nil
+}
+
+// lineAt offの後の空白と区切りを飛ばした位置の行番号
+func lineAt(b []byte, off int64) int {
+	for off < int64(len(b)) && strings.ContainsRune(" \t\r\n,", rune(b[off])) {
+		off++
+	}
+	return bytes.Count(b[:off], []byte("\n")) + 1
+}
+
+type topicTarget struct {
+	name  string
+	topic *pubsub.Topic
+	gen   *payloadGenerator
+	// run e2eの属性に付けるID。seqはtopicごとなのでrunもtopicごとに分ける
+	run string
+	// failed seqを払い出した後に失敗した数。subscriberはこれも欠番として数える
+	failed atomic.Int64
+}
+
+// topicPicker 重みに従ってtopicを選ぶ
+type topicPicker struct {
+	targets []*topicTarget
+	// cum 重みの累積
+	cum   []int
+	total int
+}
+
+func newTopicPicker(targets []*topicTarget, weights []int) (*topicPicker, error) {
+	p := &topicPicker{targets: targets}
+	for _, w := range weights {
+		p.total += w
+		p.cum = append(p.cum, p.total)
+	}
+	if p.total <= 0 {
+		return nil, fmt.Errorf("sum of weights must be > 0")
+	}
+	return p, nil
+}
+
+func (p *topicPicker) Pick() *topicTarget {
+	if len(p.targets) == 1 {
+		return p.targets[0]
+	}
+	n := rand.IntN(p.total)
+	for i, e := range p.cum {
+		if n < e {
+			return p.targets[i]
+		}
+	}
+	return p.targets[len(p.targets)-1]
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTopicSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name: "ok",
+			content: `[
+  {"name": "a", "weight": 1},
+  {"name": "b", "weight": 2}
+]`,
+			wantNames: []string{"a", "b"},
+		},
+		{
+			name: "duplicate name",
+			content: `[
+  {"name": "a", "weight": 1},
+  {"name": "b", "weight": 1},
+  {"name": "a", "weight": 2}
+]`,
+			wantErr: `:4: duplicate topic "a", first at line 2`,
+		},
+		{
+			name:    "duplicate name on one line",
+			content: `[{"name": "a"}, {"name": "a"}]`,
+			wantErr: `:1: duplicate topic "a", first at line 1`,
+		},
+		{
+			name: "no name",
+			content: `[
+  {"name": "a"},
+
+  {"weight": 1}
+]`,
+			wantErr: ":4: name must be specified",
+		},
+		{
+			name:    "negative weight",
+			content: `[{"name": "a", "weight": -1}]`,
+			wantErr: ":1: weight must be >= 0",
+		},
+		{
+			name:    "empty",
+			content: `[]`,
+			wantErr: "no topics",
+		},
+		{
+			name:    "not an array",
+			content: `{"name": "a"}`,
+			wantErr: "must be a JSON array",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "topics.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			specs, err := loadTopicSpecs(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadTopicSpecs: %v", err)
+			}
+			var names []string
+			for _, e := range specs {
+				names = append(names, e.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("names=%v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
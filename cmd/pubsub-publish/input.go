@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// inputLine jsonlの1行
+type inputLine struct {
+	Data        json.RawMessage   `json:"data"`
+	Attributes  map[string]string `json:"attributes"`
+	OrderingKey string            `json:"orderingKey"`
+}
+
+// inputMessage どのファイルの何行目から作ったメッセージか
+type inputMessage struct {
+	file string
+	line int
+	msg  *pubsub.Message
+}
+
+// messageParser 1行分のbyte列からメッセージを作る
+type messageParser func(b []byte) (*pubsub.Message, error)
+
+// newMessageParser formatは jsonl|raw、dataEncodingは text|base64|json
+func newMessageParser(format string, dataEncoding string) (messageParser, error) {
+	switch format {
+	case "raw":
+		return func(b []byte) (*pubsub.Message, error) {
+			// Scannerのバッファは次の行で上書きされるのでコピーしておく
+			return &pubsub.Message{Data: append([]byte(nil), b...)}, nil
+		}, nil
+	case "jsonl":
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+
+	var decodeData func(raw json.RawMessage) ([]byte, error)
+	switch dataEncoding {
+	case "text":
+		decodeData = func(raw json.RawMessage) ([]byte, error) {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, fmt.Errorf("data must be a string: %w", err)
+			}
+			return []byte(s), nil
+		}
+	case "base64":
+		decodeData = func(raw json.RawMessage) ([]byte, error) {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, fmt.Errorf("data must be a string: %w", err)
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("base64.DecodeString: %w", err)
+			}
+			return b, nil
+		}
+	case "json":
+		// dataに書かれたJSONの値をそのまま送る
+		decodeData = func(raw json.RawMessage) ([]byte, error) {
+			return append([]byte(nil), raw...), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown data encoding: %s", dataEncoding)
+	}
+
+	return func(b []byte) (*pubsub.Message, error) {
+		var l inputLine
+		if err := json.Unmarshal(b, &l); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %w", err)
+		}
+		msg := &pubsub.Message{
+			Attributes:  l.Attributes,
+			OrderingKey: l.OrderingKey,
+		}
+		if len(l.Data) > 0 && string(l.Data) != "null" {
+			data, err := decodeData(l.Data)
+			if err != nil {
+				return nil, err
+			}
+			msg.Data = data
+		}
+		if len(msg.Data) == 0 && len(msg.Attributes) == 0 {
+			return nil, fmt.Errorf("message must have data or attributes")
+		}
+		return msg, nil
+	}, nil
+}
+
+// readMessages filesを順に読んでchに流す。filesが空か"-"ならstdinを読む
+func readMessages(ctx context.Context, files []string, parse messageParser, maxLineSize int, ch chan<- *inputMessage) error {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	for _, fn := range files {
+		if err := func() error {
+			var r io.Reader = os.Stdin
+			if fn != "-" {
+				fp, err := os.Open(fn)
+				if err != nil {
+					return fmt.Errorf("os.Open: %w", err)
+				}
+				defer fp.Close()
+				r = fp
+			}
+
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+			line := 0
+			for scanner.Scan() {
+				line++
+				b := scanner.Bytes()
+				// 空行はdataもattributesもないメッセージになってしまうので読み飛ばす
+				if len(b) == 0 {
+					continue
+				}
+				msg, err := parse(b)
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", fn, line, err)
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case ch <- &inputMessage{file: fn, line: line, msg: msg}:
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				return fmt.Errorf("%s:%d: scanner.Err: %w", fn, line+1, err)
+			}
+			return nil
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
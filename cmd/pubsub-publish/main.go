@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/log"
+	"github.com/tckz/go-gcp-playground/internal/pubsubflag"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+var (
+	myName  = filepath.Base(os.Args[0])
+	logger  *zap.SugaredLogger
+	version string
+)
+
+var (
+	optPublish  = pubsubflag.NewPublishSettings(flag.CommandLine)
+	optLogLevel = flag.String("log-level", "info", "info|warn|error")
+	optTopic    = flag.String("topic", "", "topic name")
+
+	optFormat       = flag.String("format", "jsonl", "jsonl|raw, jsonl: {data, attributes, orderingKey} per line, raw: each line is data")
+	optDataEncoding = flag.String("data-encoding", "text", "text|base64|json, how data in jsonl is interpreted")
+	optMaxLineSize  = flag.Int("max-line-size", 10*1024*1024, "Max bytes of an input line")
+	optOrdering     = flag.Bool("message-ordering", false, "Topic.EnableMessageOrdering, required to publish with orderingKey")
+
+	optMaxInFlight = flag.Int64("max-inflight", 1000, "Max number of publishes waiting for ack")
+	optOutput      = flag.String("output", "", "path/to/results.jsonl, server ID of each message, or 'stdout' [empty = no output]")
+	optLogStep     = flag.Int64("log-step", 10000, "")
+)
+
+func init() {
+	godotenv.Load()
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [file ...]\n  reads stdin when no file or '-' is specified\n", myName)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+}
+
+// publishResult --outputに書く1行
+type publishResult struct {
+	File        string `json:"file"`
+	Line        int    `json:"line"`
+	ID          string `json:"id,omitempty"`
+	OrderingKey string `json:"orderingKey,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (c nopWriteCloser) Close() error {
+	return nil
+}
+
+func openOutput(out string) (io.WriteCloser, error) {
+	switch out {
+	case "":
+		return &nopWriteCloser{io.Discard}, nil
+	case "stdout":
+		return &nopWriteCloser{os.Stdout}, nil
+	default:
+		return os.Create(out)
+	}
+}
+
+func main() {
+	logger.Infof("ver=%s, args=%s", version, os.Args)
+	defer logger.Infof("done")
+
+	if err := run(); err != nil {
+		logger.Errorf("*** %v", err)
+		logger.Sync()
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *optTopic == "" {
+		return errors.New("--topic must be specified")
+	}
+
+	if *optMaxInFlight <= 0 {
+		return errors.New("--max-inflight must be > 0")
+	}
+
+	parse, err := newMessageParser(*optFormat, *optDataEncoding)
+	if err != nil {
+		return fmt.Errorf("newMessageParser: %w", err)
+	}
+
+	ps, err := optPublish.Settings()
+	if err != nil {
+		return fmt.Errorf("optPublish.Settings: %w", err)
+	}
+
+	out, err := openOutput(*optOutput)
+	if err != nil {
+		return fmt.Errorf("openOutput: %w", err)
+	}
+	defer out.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+		s := <-sig
+		logger.Infof("Received signal: %v", s)
+		cancel()
+	}()
+
+	pjID := os.Getenv("PROJECT_ID")
+
+	cl, err := pubsub.NewClient(ctx, pjID)
+	if err != nil {
+		return fmt.Errorf("pubsub.NewClient: %w", err)
+	}
+	defer cl.Close()
+
+	topic := cl.Topic(*optTopic)
+	topic.PublishSettings = ps
+	topic.EnableMessageOrdering = *optOrdering
+	defer topic.Stop()
+	logger.With(zap.Any("publishSettings", ps)).Infof("topic=%s", *optTopic)
+
+	var outMu sync.Mutex
+	enc := json.NewEncoder(out)
+	writeResult := func(r *publishResult) error {
+		outMu.Lock()
+		defer outMu.Unlock()
+		return enc.Encode(r)
+	}
+
+	ch := make(chan *inputMessage, 1000)
+	eg, ctxRead := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		defer close(ch)
+		return readMessages(ctxRead, flag.Args(), parse, *optMaxLineSize, ch)
+	})
+
+	// ackを待っているpublishの数を抑える
+	inflight := semaphore.NewWeighted(*optMaxInFlight)
+	var wg sync.WaitGroup
+	var published, failed, resumed int64
+	var outErr error
+	var outErrOnce sync.Once
+	begin := time.Now()
+	for m := range ch {
+		if err := inflight.Acquire(ctx, 1); err != nil {
+			break
+		}
+		res := topic.Publish(ctx, m.msg)
+		wg.Add(1)
+		go func(m *inputMessage, res *pubsub.PublishResult) {
+			defer wg.Done()
+			defer inflight.Release(1)
+
+			r := &publishResult{File: m.file, Line: m.line, OrderingKey: m.msg.OrderingKey}
+			id, err := res.Get(ctx)
+			if err != nil {
+				atomic.AddInt64(&failed, 1)
+				r.Error = err.Error()
+				logger.Warnf("Get: %s:%d, %v", m.file, m.line, err)
+				if m.msg.OrderingKey != "" && ctx.Err() == nil {
+					// ordering keyの付いたpublishが失敗するとそのkeyはResumePublishするまで受け付けられなくなる
+					topic.ResumePublish(m.msg.OrderingKey)
+					atomic.AddInt64(&resumed, 1)
+				}
+			} else {
+				r.ID = id
+				n := atomic.AddInt64(&published, 1)
+				if *optLogStep > 0 && n%*optLogStep == 0 {
+					logger.Infof("published=%d", n)
+				}
+			}
+			if err := writeResult(r); err != nil {
+				outErrOnce.Do(func() {
+					outErr = err
+					cancel()
+				})
+			}
+		}(m, res)
+	}
+	// 途中で止めた場合もreaderがchに送れずに詰まらないように読み捨てる
+	for range ch {
+	}
+	wg.Wait()
+	readErr := eg.Wait()
+
+	logger.Infof("published=%d, failed=%d, resumed=%d, elapsed=%s", published, failed, resumed, time.Since(begin))
+
+	switch {
+	case outErr != nil:
+		return fmt.Errorf("writeResult: %w", outErr)
+	case readErr != nil && !errors.Is(readErr, context.Canceled):
+		return fmt.Errorf("readMessages: %w", readErr)
+	case failed > 0:
+		return fmt.Errorf("%d messages failed to publish", failed)
+	case ctx.Err() != nil:
+		return ctx.Err()
+	}
+	return nil
+}
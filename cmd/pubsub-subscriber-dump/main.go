@@ -40,6 +40,10 @@ var (
 
 	optRaw = flag.Bool("raw", false, "dump raw body only")
 
+	optParquet         = flag.Bool("parquet", false, "dump as parquet")
+	optParquetData     = flag.String("parquet-data", "bytes", "bytes|json, column type of data in parquet")
+	optParquetRowGroup = flag.Int("parquet-row-group", 10000, "Number of rows per row group of parquet [0 = writer default]")
+
 	// out-prefixはworkerごとに別ファイル出力する際に使う
 	optOutPrefix = flag.String("out-prefix", "", "path/to/prefix")
 )
@@ -63,6 +67,10 @@ func main() {
 		logger.Fatalf("*** --subscription must be specified.")
 	}
 
+	if *optParquet && *optParquetData != "bytes" && *optParquetData != "json" {
+		logger.Fatalf("*** --parquet-data must be bytes or json.")
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -92,16 +100,31 @@ func main() {
 		}
 	}
 
-	genEncodeFunc := func(w io.Writer) encodeFunc {
-		if *optRaw {
+	nopClose := func() error { return nil }
+	genEncodeFunc := func(w io.Writer) (encodeFunc, func() error, error) {
+		if *optParquet {
+			return newParquetEncoder(w, *optParquetData, *optParquetRowGroup)
+		} else if *optRaw {
 			return func(v interface{}) error {
 				w.Write(v.([]byte))
 				w.Write([]byte("\n"))
 				return err
-			}
+			}, nopClose, nil
 		} else {
-			return json.NewEncoder(w).Encode
+			return json.NewEncoder(w).Encode, nopClose, nil
+		}
+	}
+	// parquetはfooterを書くまで完結しないのでoutLoopを抜けたらCloseする
+	encodeLoop := func(ctx context.Context, w io.Writer) error {
+		f, closeFunc, err := genEncodeFunc(w)
+		if err != nil {
+			return err
+		}
+		err = outLoop(ctx, f)
+		if cerr := closeFunc(); cerr != nil && err == nil {
+			err = cerr
 		}
+		return err
 	}
 
 	if *optOutPrefix == "" {
@@ -111,7 +134,7 @@ func main() {
 					cancel()
 				}
 			}()
-			return outLoop(ctxOut, genEncodeFunc(os.Stdout))
+			return encodeLoop(ctxOut, os.Stdout)
 		})
 	} else {
 		for i := uint(0); i < *optWorkers; i++ {
@@ -130,7 +153,7 @@ func main() {
 				}
 				defer fp.Close()
 				logger.Infof("out=%s", fn)
-				return outLoop(ctxOut, genEncodeFunc(fp))
+				return encodeLoop(ctxOut, fp)
 			})
 		}
 	}
@@ -152,7 +175,9 @@ func main() {
 			}
 
 			var m interface{}
-			if *optRaw {
+			if *optParquet {
+				m = newParquetRecord(msg)
+			} else if *optRaw {
 				m = msg.Data
 			} else {
 				m = map[string]interface{}{
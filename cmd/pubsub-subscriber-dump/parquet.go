@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRecord dataをそのままbytesとして持つ
+type parquetRecord struct {
+	ID          string            `parquet:"id"`
+	PublishTime time.Time         `parquet:"publish_time,timestamp(microsecond)"`
+	OrderingKey string            `parquet:"ordering_key"`
+	Attributes  map[string]string `parquet:"attributes"`
+	Data        []byte            `parquet:"data"`
+}
+
+// parquetJSONRecord dataがJSONである前提で文字列として持つ
+type parquetJSONRecord struct {
+	ID          string            `parquet:"id"`
+	PublishTime time.Time         `parquet:"publish_time,timestamp(microsecond)"`
+	OrderingKey string            `parquet:"ordering_key"`
+	Attributes  map[string]string `parquet:"attributes"`
+	Data        string            `parquet:"data"`
+}
+
+func newParquetRecord(msg *pubsub.Message) *parquetRecord {
+	return &parquetRecord{
+		ID:          msg.ID,
+		PublishTime: msg.PublishTime,
+		OrderingKey: msg.OrderingKey,
+		Attributes:  msg.Attributes,
+		Data:        msg.Data,
+	}
+}
+
+type parquetWriter[T any] struct {
+	w        *parquet.GenericWriter[T]
+	conv     func(r *parquetRecord) T
+	rowGroup int
+	rows     int
+}
+
+func (p *parquetWriter[T]) Encode(v interface{}) error {
+	r, ok := v.(*parquetRecord)
+	if !ok {
+		return fmt.Errorf("unexpected type: %T", v)
+	}
+
+	if _, err := p.w.Write([]T{p.conv(r)}); err != nil {
+		return fmt.Errorf("parquet.Write: %w", err)
+	}
+
+	p.rows++
+	if p.rowGroup > 0 && p.rows >= p.rowGroup {
+		if err := p.w.Flush(); err != nil {
+			return fmt.Errorf("parquet.Flush: %w", err)
+		}
+		p.rows = 0
+	}
+	return nil
+}
+
+func (p *parquetWriter[T]) Close() error {
+	if err := p.w.Close(); err != nil {
+		return fmt.Errorf("parquet.Close: %w", err)
+	}
+	return nil
+}
+
+// newParquetEncoder dataTypeは bytes|json
+func newParquetEncoder(w io.Writer, dataType string, rowGroup int) (encodeFunc, func() error, error) {
+	switch dataType {
+	case "bytes":
+		p := &parquetWriter[parquetRecord]{
+			w:        parquet.NewGenericWriter[parquetRecord](w),
+			conv:     func(r *parquetRecord) parquetRecord { return *r },
+			rowGroup: rowGroup,
+		}
+		return p.Encode, p.Close, nil
+	case "json":
+		p := &parquetWriter[parquetJSONRecord]{
+			w: parquet.NewGenericWriter[parquetJSONRecord](w),
+			conv: func(r *parquetRecord) parquetJSONRecord {
+				return parquetJSONRecord{
+					ID:          r.ID,
+					PublishTime: r.PublishTime,
+					OrderingKey: r.OrderingKey,
+					Attributes:  r.Attributes,
+					Data:        string(r.Data),
+				}
+			},
+			rowGroup: rowGroup,
+		}
+		return p.Encode, p.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown parquet data type: %s", dataType)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"math/bits"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tckz/go-gcp-playground/internal/e2e"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+	"go.uber.org/zap"
+)
+
+// e2eRun publisherの1回の実行分の集計
+type e2eRun struct {
+	metrics vegeta.Metrics
+	// seen 受信済みのseqのビット集合
+	seen       []uint64
+	maxSeq     int64
+	received   int64
+	duplicates int64
+	// dropped 上限を超えたseq。ビット集合が際限なく大きくならないように捨てる
+	dropped int64
+}
+
+// mark 初めて見たseqならtrue
+func (r *e2eRun) mark(seq int64) bool {
+	i, b := seq/64, uint64(1)<<(seq%64)
+	for int64(len(r.seen)) <= i {
+		r.seen = append(r.seen, 0)
+	}
+	if r.seen[i]&b != 0 {
+		return false
+	}
+	r.seen[i] |= b
+	return true
+}
+
+func (r *e2eRun) unique() int64 {
+	n := 0
+	for _, e := range r.seen {
+		n += bits.OnesCount64(e)
+	}
+	return int64(n)
+}
+
+// e2eTracker publisherが付けたe2e属性からpublishから受信までのlatencyと欠番、重複を集計する
+type e2eTracker struct {
+	mu      sync.Mutex
+	runs    map[string]*e2eRun
+	buckets vegeta.Buckets
+	// maxSeq これより大きいseqは捨てる
+	maxSeq int64
+	// maxGap 受信済みの最大のseqからこれより先に飛んだseqは捨てる
+	maxGap int64
+
+	// 受信のたびにロックを持ったまま書かないように別goroutineで書く
+	results chan *vegeta.Result
+	done    chan error
+}
+
+// newE2ETracker wがnilならvegetaの結果を出力しない。Closeで書き終わるのを待つこと
+func newE2ETracker(buckets vegeta.Buckets, maxSeq, maxGap int64, w io.Writer) *e2eTracker {
+	t := &e2eTracker{
+		runs:    map[string]*e2eRun{},
+		buckets: buckets,
+		maxSeq:  maxSeq,
+		maxGap:  maxGap,
+	}
+	if w != nil {
+		t.results = make(chan *vegeta.Result, 1000)
+		t.done = make(chan error, 1)
+		go func() {
+			enc := vegeta.NewEncoder(w)
+			var err error
+			for res := range t.results {
+				if err == nil {
+					err = enc.Encode(res)
+				}
+			}
+			t.done <- err
+		}()
+	}
+	return t
+}
+
+// Observe 上限を超えたseqならfalse
+func (t *e2eTracker) Observe(s e2e.Stamp, receivedAt time.Time) bool {
+	res, ok := t.observe(s, receivedAt)
+	if res != nil && t.results != nil {
+		t.results <- res
+	}
+	return ok
+}
+
+// observe 初めて見たseqなら書き出す結果を返す
+func (t *e2eTracker) observe(s e2e.Stamp, receivedAt time.Time) (*vegeta.Result, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.runs[s.Run]
+	if !ok {
+		r = &e2eRun{}
+		if len(t.buckets) > 0 {
+			r.metrics.Histogram = &vegeta.Histogram{Buckets: t.buckets}
+		}
+		t.runs[s.Run] = r
+	}
+
+	if s.Seq > t.maxSeq || s.Seq-r.maxSeq > t.maxGap {
+		r.dropped++
+		return nil, false
+	}
+
+	r.received++
+	if s.Seq > r.maxSeq {
+		r.maxSeq = s.Seq
+	}
+	if s.Seq < 0 || !r.mark(s.Seq) {
+		r.duplicates++
+		return nil, true
+	}
+
+	res := &vegeta.Result{
+		Attack:    s.Run,
+		Seq:       uint64(s.Seq),
+		Code:      http.StatusOK,
+		Timestamp: s.SentAt,
+		Latency:   receivedAt.Sub(s.SentAt),
+	}
+	r.metrics.Add(res)
+	return res, true
+}
+
+// Close 結果を書き終わるのを待つ。Observeと並行して呼ばないこと
+func (t *e2eTracker) Close() error {
+	if t.results == nil {
+		return nil
+	}
+	close(t.results)
+	return <-t.done
+}
+
+// Report runごとの集計をログに出し、バケットの指定があればヒストグラムをwに書く
+func (t *e2eTracker) Report(logger *zap.SugaredLogger, w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	runs := make([]string, 0, len(t.runs))
+	for k := range t.runs {
+		runs = append(runs, k)
+	}
+	sort.Strings(runs)
+
+	for _, k := range runs {
+		r := t.runs[k]
+		r.metrics.Close()
+		unique := r.unique()
+		l := r.metrics.Latencies
+		logger.With(
+			zap.String("run", k),
+			zap.Int64("received", r.received),
+			zap.Int64("unique", unique),
+			zap.Int64("duplicates", r.duplicates),
+			zap.Int64("dropped", r.dropped),
+			// seqは1から始まるので最大のseqまでで届いていないものを欠番とみなす。
+			// publishに失敗したseqも含むのでpublisherのログのrunごとのfailedと突き合わせる
+			zap.Int64("missing", r.maxSeq-unique),
+			zap.Int64("maxSeq", r.maxSeq),
+			zap.Duration("min", l.Min),
+			zap.Duration("mean", l.Mean),
+			zap.Duration("p50", l.P50),
+			zap.Duration("p90", l.P90),
+			zap.Duration("p95", l.P95),
+			zap.Duration("p99", l.P99),
+			zap.Duration("max", l.Max),
+		).Infof("e2e latency")
+
+		if r.metrics.Histogram != nil {
+			if err := vegeta.NewHistogramReporter(r.metrics.Histogram).Report(w); err != nil {
+				logger.Errorf("HistogramReporter: %v", err)
+			}
+		}
+	}
+}
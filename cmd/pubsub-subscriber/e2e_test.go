@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/tckz/go-gcp-playground/internal/e2e"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestE2ETrackerObserve(t *testing.T) {
+	sentAt := time.Unix(1700000000, 0)
+	tests := []struct {
+		name           string
+		seqs           []int64
+		wantReceived   int64
+		wantUnique     int64
+		wantDuplicates int64
+		wantDropped    int64
+		wantMaxSeq     int64
+		wantRejected   int
+	}{
+		{
+			name:         "in order",
+			seqs:         []int64{1, 2, 3},
+			wantReceived: 3, wantUnique: 3, wantMaxSeq: 3,
+		},
+		{
+			name:         "duplicates",
+			seqs:         []int64{1, 2, 2, 1, 3},
+			wantReceived: 5, wantUnique: 3, wantDuplicates: 2, wantMaxSeq: 3,
+		},
+		{
+			name:         "negative seq is a duplicate",
+			seqs:         []int64{1, -1},
+			wantReceived: 2, wantUnique: 1, wantDuplicates: 1, wantMaxSeq: 1,
+		},
+		{
+			name:         "above max seq",
+			seqs:         []int64{1, 1001},
+			wantReceived: 1, wantUnique: 1, wantDropped: 1, wantMaxSeq: 1, wantRejected: 1,
+		},
+		{
+			name:         "too far past the high-water mark",
+			seqs:         []int64{1, 150, 400, 120},
+			wantReceived: 3, wantUnique: 3, wantDropped: 1, wantMaxSeq: 150, wantRejected: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newE2ETracker(nil, 1000, 199, nil)
+			rejected := 0
+			for _, seq := range tt.seqs {
+				if !tr.Observe(e2e.Stamp{Run: "r", Seq: seq, SentAt: sentAt}, sentAt.Add(time.Second)) {
+					rejected++
+				}
+			}
+			if err := tr.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			r := tr.runs["r"]
+			if r.received != tt.wantReceived {
+				t.Errorf("received=%d, want %d", r.received, tt.wantReceived)
+			}
+			if got := r.unique(); got != tt.wantUnique {
+				t.Errorf("unique=%d, want %d", got, tt.wantUnique)
+			}
+			if r.duplicates != tt.wantDuplicates {
+				t.Errorf("duplicates=%d, want %d", r.duplicates, tt.wantDuplicates)
+			}
+			if r.dropped != tt.wantDropped {
+				t.Errorf("dropped=%d, want %d", r.dropped, tt.wantDropped)
+			}
+			if r.maxSeq != tt.wantMaxSeq {
+				t.Errorf("maxSeq=%d, want %d", r.maxSeq, tt.wantMaxSeq)
+			}
+			if rejected != tt.wantRejected {
+				t.Errorf("rejected=%d, want %d", rejected, tt.wantRejected)
+			}
+			// 捨てたseqでビット集合は大きくならない
+			if max := int(tt.wantMaxSeq/64 + 1); len(r.seen) > max {
+				t.Errorf("len(seen)=%d, want <= %d", len(r.seen), max)
+			}
+		})
+	}
+}
+
+func TestE2ETrackerWritesResults(t *testing.T) {
+	var buf bytes.Buffer
+	tr := newE2ETracker(nil, 1000, 1000, &buf)
+	sentAt := time.Unix(1700000000, 0)
+	for _, seq := range []int64{1, 2, 2, 3} {
+		tr.Observe(e2e.Stamp{Run: "r", Seq: seq, SentAt: sentAt}, sentAt.Add(time.Duration(seq)*time.Millisecond))
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	dec := vegeta.NewDecoder(&buf)
+	var seqs []uint64
+	for {
+		var res vegeta.Result
+		if err := dec.Decode(&res); err != nil {
+			break
+		}
+		seqs = append(seqs, res.Seq)
+		if want := time.Duration(res.Seq) * time.Millisecond; res.Latency != want {
+			t.Errorf("seq=%d: latency=%s, want %s", res.Seq, res.Latency, want)
+		}
+	}
+	// 重複は書かない
+	if len(seqs) != 3 {
+		t.Errorf("results=%v, want 3 results", seqs)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -11,8 +12,8 @@ import (
 
 	"cloud.google.com/go/pubsub"
 	"github.com/joho/godotenv"
-	"github.com/patrickmn/go-cache"
-	"github.com/redis/go-redis/v9"
+	"github.com/tckz/go-gcp-playground/internal/dedup"
+	"github.com/tckz/go-gcp-playground/internal/e2e"
 	"github.com/tckz/go-gcp-playground/internal/log"
 	vegeta "github.com/tsenart/vegeta/v12/lib"
 	"go.uber.org/zap"
@@ -31,17 +32,22 @@ var (
 	optSubscription = flag.String("subscription", "", "subscription name")
 	optRedis        = flag.String("redis", "", "addr:port of redis")
 	optCounterKey   = flag.String("counter-key", "subscriber-counter", "key of redis")
+
+	optE2EBuckets = flag.String("e2e-buckets", "", "Histogram buckets of e2e latency, e.g. '[0,10ms,100ms,1s]'")
+	optE2EOutput  = flag.String("e2e-output", "", "/path/to/e2e-results.bin, vegeta results of e2e latency")
+	optE2EMaxSeq  = flag.Int64("e2e-max-seq", 100_000_000, "drop e2e stamps with seq above this, to bound memory for received seqs")
+	optE2EMaxGap  = flag.Int64("e2e-max-gap", 1_000_000, "drop e2e stamps with seq this far past the highest seq received")
 )
 
 func init() {
 	godotenv.Load()
+}
 
+func main() {
 	flag.Parse()
 
 	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
-}
 
-func main() {
 	logger.Infof("ver=%s, args=%s", version, os.Args)
 	defer logger.Infof("done")
 
@@ -60,30 +66,49 @@ func main() {
 	}
 	defer cl.Close()
 
-	var counter Counter
-	var processMarker ProcessMarker
+	var counter dedup.Counter
+	var processMarker dedup.ProcessMarker
 	if *optRedis == "" {
-		counter = &LocalCounter{}
-		processMarker = &LocalMarker{cache: cache.New(1*time.Minute, 1*time.Minute)}
+		counter = &dedup.LocalCounter{}
+		processMarker = dedup.NewLocalMarker(1 * time.Minute)
 	} else {
-		cl := redis.NewUniversalClient(&redis.UniversalOptions{
-			Addrs:        []string{*optRedis},
-			DialTimeout:  time.Second * 2,
-			ReadTimeout:  time.Second * 2,
-			WriteTimeout: time.Second * 2,
-			PoolSize:     200,
-			PoolTimeout:  time.Second * 5,
-		})
+		cl := dedup.NewRedisClient(*optRedis, 200)
 		defer cl.Close()
-		counter = &RedisCounter{key: *optCounterKey, client: cl}
-		processMarker = &RedisMarker{client: cl}
+		counter = dedup.NewRedisCounter(cl, *optCounterKey)
+		processMarker = dedup.NewRedisMarker(cl, 60*time.Second)
 	}
 
+	var buckets vegeta.Buckets
+	if *optE2EBuckets != "" {
+		if err := buckets.UnmarshalText([]byte(*optE2EBuckets)); err != nil {
+			logger.Fatalf("*** --e2e-buckets: %v", err)
+		}
+	}
+	var e2eOut io.Writer
+	if *optE2EOutput != "" {
+		fp, err := os.Create(*optE2EOutput)
+		if err != nil {
+			logger.Fatalf("*** os.Create: %v", err)
+		}
+		defer fp.Close()
+		e2eOut = fp
+	}
+	tracker := newE2ETracker(buckets, *optE2EMaxSeq, *optE2EMaxGap, e2eOut)
+
 	eg, ctx := errgroup.WithContext(ctx)
 	for i := uint64(0); i < *optWorkers; i++ {
 		eg.Go(func() error {
 			subs := cl.Subscription(*optSubscription)
 			return subs.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+				// 重複も数えたいのでprocessMarkerより前で見る
+				if stamp, ok, err := e2e.Parse(msg.Attributes); err != nil {
+					logger.Warnf("e2e.Parse: msgID=%s, %v", msg.ID, err)
+				} else if ok {
+					if !tracker.Observe(stamp, time.Now()) {
+						logger.Warnf("e2e seq out of range: msgID=%s, run=%s, seq=%d", msg.ID, stamp.Run, stamp.Seq)
+					}
+				}
+
 				if got, err := processMarker.Acquire(ctx, msg.ID); err != nil {
 					logger.Errorf("ProcessCheck: %v", err)
 					return
@@ -121,4 +146,9 @@ func main() {
 		v, _ := counter.Get(ctx)
 		logger.Infof("Counter=%d", v)
 	}
+
+	if err := tracker.Close(); err != nil {
+		logger.Errorf("tracker.Close: %v", err)
+	}
+	tracker.Report(logger, os.Stderr)
 }
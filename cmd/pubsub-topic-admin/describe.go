@@ -0,0 +1,70 @@
+package main
+
+import (
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+type schemaDescription struct {
+	Schema          string `json:"schema"`
+	Encoding        string `json:"encoding"`
+	FirstRevisionID string `json:"firstRevisionId,omitempty"`
+	LastRevisionID  string `json:"lastRevisionId,omitempty"`
+}
+
+// topicDescription describeで出力するTopicConfig
+type topicDescription struct {
+	Name                      string             `json:"name"`
+	Labels                    map[string]string  `json:"labels,omitempty"`
+	RetentionDuration         string             `json:"retentionDuration,omitempty"`
+	Schema                    *schemaDescription `json:"schema,omitempty"`
+	KMSKeyName                string             `json:"kmsKeyName,omitempty"`
+	AllowedPersistenceRegions []string           `json:"allowedPersistenceRegions,omitempty"`
+	State                     string             `json:"state"`
+}
+
+func schemaEncodingString(e pubsub.SchemaEncoding) string {
+	switch e {
+	case pubsub.EncodingJSON:
+		return "json"
+	case pubsub.EncodingBinary:
+		return "binary"
+	default:
+		return "unspecified"
+	}
+}
+
+func topicStateString(s pubsub.TopicState) string {
+	switch s {
+	case pubsub.TopicStateActive:
+		return "active"
+	case pubsub.TopicStateIngestionResourceError:
+		return "ingestion-resource-error"
+	default:
+		return "unspecified"
+	}
+}
+
+func newTopicDescription(cfg *pubsub.TopicConfig) *topicDescription {
+	d := &topicDescription{
+		Name:                      cfg.String(),
+		Labels:                    cfg.Labels,
+		KMSKeyName:                cfg.KMSKeyName,
+		AllowedPersistenceRegions: cfg.MessageStoragePolicy.AllowedPersistenceRegions,
+		State:                     topicStateString(cfg.State),
+	}
+	// 保持期間の指定がなければnil
+	if v, ok := cfg.RetentionDuration.(time.Duration); ok {
+		d.RetentionDuration = v.String()
+	}
+	if s := cfg.SchemaSettings; s != nil {
+		d.Schema = &schemaDescription{
+			Schema:          s.Schema,
+			Encoding:        schemaEncodingString(s.Encoding),
+			FirstRevisionID: s.FirstRevisionID,
+			LastRevisionID:  s.LastRevisionID,
+		}
+	}
+	return d
+}
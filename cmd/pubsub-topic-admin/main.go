@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/joho/godotenv"
+	"github.com/tckz/go-gcp-playground/internal/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	myName  = filepath.Base(os.Args[0])
+	logger  *zap.SugaredLogger
+	version string
+)
+
+var (
+	optLogLevel = flag.String("log-level", "info", "info|warn|error")
+	optTopic    = flag.String("topic", "", "topic name")
+
+	optRetention      = flag.Duration("retention", 0, "message retention duration of the topic [0 = not retained]")
+	optLabels         = flag.String("labels", "", "comma separated key=value labels of the topic")
+	optSchema         = flag.String("schema", "", "schema name or projects/{project}/schemas/{schema} bound to the topic")
+	optSchemaEncoding = flag.String("schema-encoding", "json", "json|binary, encoding of messages validated against --schema")
+	optFirstRevision  = flag.String("schema-first-revision", "", "oldest revision of --schema that messages are validated against")
+	optLastRevision   = flag.String("schema-last-revision", "", "newest revision of --schema that messages are validated against")
+	optKMSKey         = flag.String("kms-key", "", "projects/{project}/locations/{location}/keyRings/{ring}/cryptoKeys/{key} to protect messages")
+	optAllowedRegions = flag.String("allowed-regions", "", "comma separated regions where messages may be stored [empty = project default]")
+
+	optSubscription = flag.String("subscription", "", "subscription name to detach")
+)
+
+func init() {
+	godotenv.Load()
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] create|update|delete|describe|subscriptions|detach\n", myName)
+		flag.PrintDefaults()
+	}
+}
+
+// topicOptions create/updateで指定するtopicの設定
+type topicOptions struct {
+	retention      time.Duration
+	labels         map[string]string
+	schema         string
+	schemaEncoding string
+	firstRevision  string
+	lastRevision   string
+	kmsKey         string
+	allowedRegions []string
+}
+
+// fullSchemaName projects/から始まっていなければpjIDのスキーマとみなす
+func fullSchemaName(pjID, s string) string {
+	if strings.HasPrefix(s, "projects/") {
+		return s
+	}
+	return fmt.Sprintf("projects/%s/schemas/%s", pjID, s)
+}
+
+func parseSchemaEncoding(s string) (pubsub.SchemaEncoding, error) {
+	switch s {
+	case "json":
+		return pubsub.EncodingJSON, nil
+	case "binary":
+		return pubsub.EncodingBinary, nil
+	default:
+		return 0, fmt.Errorf("unknown schema encoding: %s", s)
+	}
+}
+
+// parseLabels key=value,key=value
+func parseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ret := map[string]string{}
+	for _, e := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(e, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("label must be key=value: %s", e)
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func (o *topicOptions) schemaSettings(pjID string) (*pubsub.SchemaSettings, error) {
+	if o.schema == "" {
+		if o.firstRevision != "" || o.lastRevision != "" {
+			return nil, errors.New("schema revisions require a schema")
+		}
+		return nil, nil
+	}
+	enc, err := parseSchemaEncoding(o.schemaEncoding)
+	if err != nil {
+		return nil, err
+	}
+	return &pubsub.SchemaSettings{
+		Schema:          fullSchemaName(pjID, o.schema),
+		Encoding:        enc,
+		FirstRevisionID: o.firstRevision,
+		LastRevisionID:  o.lastRevision,
+	}, nil
+}
+
+func newTopicConfig(pjID string, o topicOptions) (*pubsub.TopicConfig, error) {
+	cfg := &pubsub.TopicConfig{
+		Labels:     o.labels,
+		KMSKeyName: o.kmsKey,
+	}
+	if o.retention > 0 {
+		cfg.RetentionDuration = o.retention
+	}
+	ss, err := o.schemaSettings(pjID)
+	if err != nil {
+		return nil, err
+	}
+	cfg.SchemaSettings = ss
+	cfg.MessageStoragePolicy.AllowedPersistenceRegions = o.allowedRegions
+	return cfg, nil
+}
+
+func createTopic(ctx context.Context, cl *pubsub.Client, topicID string, o topicOptions) (*pubsub.Topic, error) {
+	cfg, err := newTopicConfig(cl.Project(), o)
+	if err != nil {
+		return nil, fmt.Errorf("newTopicConfig: %w", err)
+	}
+	t, err := cl.CreateTopicWithConfig(ctx, topicID, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("CreateTopicWithConfig: %w", err)
+	}
+	return t, nil
+}
+
+// updateTopic 指定された設定だけを変える。スキーマのリビジョンは指定しなければ元の値が残る。KMSキーは作成後に変えられない
+func updateTopic(ctx context.Context, cl *pubsub.Client, topicID string, o topicOptions) (pubsub.TopicConfig, error) {
+	if o.kmsKey != "" {
+		return pubsub.TopicConfig{}, errors.New("kms key cannot be updated")
+	}
+	var u pubsub.TopicConfigToUpdate
+	if o.retention > 0 {
+		u.RetentionDuration = o.retention
+	}
+	if o.labels != nil {
+		u.Labels = o.labels
+	}
+	ss, err := o.schemaSettings(cl.Project())
+	if err != nil {
+		return pubsub.TopicConfig{}, err
+	}
+	u.SchemaSettings = ss
+	if o.allowedRegions != nil {
+		u.MessageStoragePolicy = &pubsub.MessageStoragePolicy{AllowedPersistenceRegions: o.allowedRegions}
+	}
+	if u.RetentionDuration == nil && u.Labels == nil && u.SchemaSettings == nil && u.MessageStoragePolicy == nil {
+		return pubsub.TopicConfig{}, errors.New("nothing to update")
+	}
+
+	cfg, err := cl.Topic(topicID).Update(ctx, u)
+	if err != nil {
+		return pubsub.TopicConfig{}, fmt.Errorf("Update: %w", err)
+	}
+	return cfg, nil
+}
+
+func deleteTopic(ctx context.Context, cl *pubsub.Client, topicID string) error {
+	if err := cl.Topic(topicID).Delete(ctx); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	return nil
+}
+
+func describeTopic(ctx context.Context, cl *pubsub.Client, topicID string, w io.Writer) error {
+	cfg, err := cl.Topic(topicID).Config(ctx)
+	if err != nil {
+		return fmt.Errorf("Config: %w", err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(newTopicDescription(&cfg)); err != nil {
+		return fmt.Errorf("Encode: %w", err)
+	}
+	return nil
+}
+
+func listSubscriptions(ctx context.Context, cl *pubsub.Client, topicID string, w io.Writer) error {
+	it := cl.Topic(topicID).Subscriptions(ctx)
+	for {
+		s, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("it.Next: %w", err)
+		}
+		fmt.Fprintln(w, s)
+	}
+}
+
+// detachSubscription 指定のtopicに紐付いていないsubscriptionを誤ってdetachしないよう確認する
+func detachSubscription(ctx context.Context, cl *pubsub.Client, topicID string, subID string) error {
+	topic := cl.Topic(topicID)
+	sub := cl.Subscription(subID)
+	sc, err := sub.Config(ctx)
+	if err != nil {
+		return fmt.Errorf("sub.Config: %w", err)
+	}
+	if sc.Topic == nil || sc.Topic.String() != topic.String() {
+		return fmt.Errorf("%s is not a subscription of %s", sub, topic)
+	}
+	if _, err := cl.DetachSubscription(ctx, sub.String()); err != nil {
+		return fmt.Errorf("DetachSubscription: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	logger = log.Must(log.NewLogger(log.WithLogLevel(*optLogLevel))).Sugar().With(zap.String("app", myName))
+	logger.Infof("ver=%s, args=%s", version, os.Args)
+	defer logger.Infof("done")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		logger.Fatalf("*** command must be specified.")
+	}
+	command := flag.Arg(0)
+
+	if *optTopic == "" {
+		logger.Fatalf("*** --topic must be specified.")
+	}
+
+	labels, err := parseLabels(*optLabels)
+	if err != nil {
+		logger.Fatalf("*** --labels: %v", err)
+	}
+	o := topicOptions{
+		retention:      *optRetention,
+		labels:         labels,
+		schema:         *optSchema,
+		schemaEncoding: *optSchemaEncoding,
+		firstRevision:  *optFirstRevision,
+		lastRevision:   *optLastRevision,
+		kmsKey:         *optKMSKey,
+		allowedRegions: splitList(*optAllowedRegions),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	pjID := os.Getenv("PROJECT_ID")
+
+	cl, err := pubsub.NewClient(ctx, pjID)
+	if err != nil {
+		logger.Fatalf("*** pubsub.NewClient: %v", err)
+	}
+	defer cl.Close()
+
+	switch command {
+	case "create":
+		t, err := createTopic(ctx, cl, *optTopic, o)
+		if err != nil {
+			logger.Fatalf("*** createTopic: %v", err)
+		}
+		logger.Infof("created: %s", t)
+	case "update":
+		cfg, err := updateTopic(ctx, cl, *optTopic, o)
+		if err != nil {
+			logger.Fatalf("*** updateTopic: %v", err)
+		}
+		logger.Infof("updated: %s", cfg)
+	case "delete":
+		if err := deleteTopic(ctx, cl, *optTopic); err != nil {
+			logger.Fatalf("*** deleteTopic: %v", err)
+		}
+		logger.Infof("deleted: %s", *optTopic)
+	case "describe":
+		if err := describeTopic(ctx, cl, *optTopic, os.Stdout); err != nil {
+			logger.Fatalf("*** describeTopic: %v", err)
+		}
+	case "subscriptions":
+		if err := listSubscriptions(ctx, cl, *optTopic, os.Stdout); err != nil {
+			logger.Fatalf("*** listSubscriptions: %v", err)
+		}
+	case "detach":
+		if *optSubscription == "" {
+			logger.Fatalf("*** --subscription must be specified.")
+		}
+		if err := detachSubscription(ctx, cl, *optTopic, *optSubscription); err != nil {
+			logger.Fatalf("*** detachSubscription: %v", err)
+		}
+		logger.Infof("detached: %s", *optSubscription)
+	default:
+		flag.Usage()
+		logger.Fatalf("*** unknown command: %s", command)
+	}
+}
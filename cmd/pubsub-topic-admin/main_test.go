@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func newTestClient(t *testing.T) *pubsub.Client {
+	t.Helper()
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := pubsub.NewClient(ctx, "pj", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func describe(t *testing.T, cl *pubsub.Client, topicID string) *topicDescription {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := describeTopic(context.Background(), cl, topicID, &buf); err != nil {
+		t.Fatalf("describeTopic: %v", err)
+	}
+	var d topicDescription
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatalf("json.Unmarshal: %v, %s", err, buf.String())
+	}
+	return &d
+}
+
+func TestTopicRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cl := newTestClient(t)
+
+	o := topicOptions{
+		retention:      2 * time.Hour,
+		labels:         map[string]string{"env": "test"},
+		schema:         "s",
+		schemaEncoding: "binary",
+		firstRevision:  "r1",
+		lastRevision:   "r2",
+		kmsKey:         "projects/pj/locations/global/keyRings/r/cryptoKeys/k",
+		allowedRegions: []string{"asia-northeast1"},
+	}
+	if _, err := createTopic(ctx, cl, "t", o); err != nil {
+		t.Fatalf("createTopic: %v", err)
+	}
+
+	want := &topicDescription{
+		Name:              "projects/pj/topics/t",
+		Labels:            map[string]string{"env": "test"},
+		RetentionDuration: "2h0m0s",
+		Schema: &schemaDescription{
+			Schema:          "projects/pj/schemas/s",
+			Encoding:        "binary",
+			FirstRevisionID: "r1",
+			LastRevisionID:  "r2",
+		},
+		KMSKeyName:                "projects/pj/locations/global/keyRings/r/cryptoKeys/k",
+		AllowedPersistenceRegions: []string{"asia-northeast1"},
+		State:                     "unspecified",
+	}
+	if got := describe(t, cl, "t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("describe=%+v, want %+v", got, want)
+	}
+
+	// 指定したものだけが変わる
+	_, err := updateTopic(ctx, cl, "t", topicOptions{
+		labels:         map[string]string{"env": "prod", "team": "a"},
+		schema:         "projects/other/schemas/s2",
+		schemaEncoding: "json",
+	})
+	if err != nil {
+		t.Fatalf("updateTopic: %v", err)
+	}
+	want.Labels = map[string]string{"env": "prod", "team": "a"}
+	// 指定しなかったリビジョンはそのまま残る
+	want.Schema = &schemaDescription{Schema: "projects/other/schemas/s2", Encoding: "json", FirstRevisionID: "r1", LastRevisionID: "r2"}
+	if got := describe(t, cl, "t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("describe=%+v, want %+v", got, want)
+	}
+
+	if err := deleteTopic(ctx, cl, "t"); err != nil {
+		t.Fatalf("deleteTopic: %v", err)
+	}
+	if err := describeTopic(ctx, cl, "t", &bytes.Buffer{}); status.Code(err) != codes.NotFound {
+		t.Errorf("describe after delete: %v, want NotFound", err)
+	}
+}
+
+func TestTopicErrors(t *testing.T) {
+	ctx := context.Background()
+	cl := newTestClient(t)
+	if _, err := createTopic(ctx, cl, "exists", topicOptions{}); err != nil {
+		t.Fatalf("createTopic: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		f        func() error
+		wantCode codes.Code
+		wantErr  string
+	}{
+		{
+			name:     "create existing",
+			f:        func() error { _, err := createTopic(ctx, cl, "exists", topicOptions{}); return err },
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name: "create with bad encoding",
+			f: func() error {
+				_, err := createTopic(ctx, cl, "t", topicOptions{schema: "s", schemaEncoding: "xml"})
+				return err
+			},
+			wantErr: "unknown schema encoding",
+		},
+		{
+			name: "revision without schema",
+			f: func() error {
+				_, err := createTopic(ctx, cl, "t", topicOptions{lastRevision: "r"})
+				return err
+			},
+			wantErr: "require a schema",
+		},
+		{
+			name: "update missing",
+			f: func() error {
+				_, err := updateTopic(ctx, cl, "missing", topicOptions{labels: map[string]string{"a": "b"}})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name:    "update nothing",
+			f:       func() error { _, err := updateTopic(ctx, cl, "exists", topicOptions{}); return err },
+			wantErr: "nothing to update",
+		},
+		{
+			name:    "update kms key",
+			f:       func() error { _, err := updateTopic(ctx, cl, "exists", topicOptions{kmsKey: "k"}); return err },
+			wantErr: "kms key",
+		},
+		{
+			name:     "delete missing",
+			f:        func() error { return deleteTopic(ctx, cl, "missing") },
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "describe missing",
+			f:        func() error { return describeTopic(ctx, cl, "missing", &bytes.Buffer{}) },
+			wantCode: codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.f()
+			if err == nil {
+				t.Fatalf("err=nil")
+			}
+			if tt.wantCode != codes.OK && status.Code(err) != tt.wantCode {
+				t.Errorf("code=%v, want %v, %v", status.Code(err), tt.wantCode, err)
+			}
+			if tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err=%v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubscriptionsAndDetach(t *testing.T) {
+	ctx := context.Background()
+	cl := newTestClient(t)
+	for _, id := range []string{"t", "other"} {
+		if _, err := createTopic(ctx, cl, id, topicOptions{}); err != nil {
+			t.Fatalf("createTopic: %v", err)
+		}
+	}
+	for _, e := range []struct{ sub, topic string }{{"s1", "t"}, {"s2", "t"}, {"s3", "other"}} {
+		if _, err := cl.CreateSubscription(ctx, e.sub, pubsub.SubscriptionConfig{Topic: cl.Topic(e.topic)}); err != nil {
+			t.Fatalf("CreateSubscription: %v", err)
+		}
+	}
+
+	list := func() string {
+		var buf bytes.Buffer
+		if err := listSubscriptions(ctx, cl, "t", &buf); err != nil {
+			t.Fatalf("listSubscriptions: %v", err)
+		}
+		return buf.String()
+	}
+	if got, want := list(), "projects/pj/subscriptions/s1\nprojects/pj/subscriptions/s2\n"; got != want {
+		t.Errorf("subscriptions=%q, want %q", got, want)
+	}
+
+	if err := detachSubscription(ctx, cl, "t", "s3"); err == nil || !strings.Contains(err.Error(), "is not a subscription of") {
+		t.Errorf("detach other topic's subscription: %v", err)
+	}
+	if err := detachSubscription(ctx, cl, "t", "missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("detach missing: %v, want NotFound", err)
+	}
+	// pstestはdetachしてもtopicのsubscriptionの一覧からは消えない
+	if err := detachSubscription(ctx, cl, "t", "s1"); err != nil {
+		t.Fatalf("detachSubscription: %v", err)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "a=b", want: map[string]string{"a": "b"}},
+		{in: "a=b,c=", want: map[string]string{"a": "b", "c": ""}},
+		{in: "a", wantErr: true},
+		{in: "=b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLabels(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLabels(%q) err=%v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLabels(%q)=%v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,8 @@
-package main
+package dedup
 
 import (
 	"context"
+	"time"
 
 	"github.com/patrickmn/go-cache"
 )
@@ -17,6 +18,11 @@ type LocalMarker struct {
 	cache *cache.Cache
 }
 
+// NewLocalMarker ttlを過ぎたマークは消える
+func NewLocalMarker(ttl time.Duration) *LocalMarker {
+	return &LocalMarker{cache: cache.New(ttl, ttl)}
+}
+
 func (c *LocalMarker) Acquire(ctx context.Context, msgID string) (bool, error) {
 	err := c.cache.Add(msgID, struct{}{}, 0)
 	return err == nil, nil
@@ -0,0 +1,35 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalMarker(t *testing.T) {
+	ctx := context.Background()
+	m := NewLocalMarker(50 * time.Millisecond)
+
+	for _, tt := range []struct {
+		msgID string
+		want  bool
+	}{
+		{"a", true},
+		{"a", false},
+		{"b", true},
+	} {
+		got, err := m.Acquire(ctx, tt.msgID)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Acquire(%s)=%t, want %t", tt.msgID, got, tt.want)
+		}
+	}
+
+	// TTLを過ぎれば同じIDでも再び処理権が得られる
+	time.Sleep(80 * time.Millisecond)
+	if got, _ := m.Acquire(ctx, "a"); !got {
+		t.Errorf("Acquire(a) after ttl=false, want true")
+	}
+}
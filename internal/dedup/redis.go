@@ -0,0 +1,18 @@
+package dedup
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func NewRedisClient(addr string, poolSize int) redis.UniversalClient {
+	return redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:        []string{addr},
+		DialTimeout:  time.Second * 2,
+		ReadTimeout:  time.Second * 2,
+		WriteTimeout: time.Second * 2,
+		PoolSize:     poolSize,
+		PoolTimeout:  time.Second * 5,
+	})
+}
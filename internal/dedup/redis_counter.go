@@ -1,4 +1,4 @@
-package main
+package dedup
 
 import (
 	"context"
@@ -13,6 +13,10 @@ type RedisCounter struct {
 	client redis.UniversalClient
 }
 
+func NewRedisCounter(client redis.UniversalClient, key string) *RedisCounter {
+	return &RedisCounter{key: key, client: client}
+}
+
 func (c *RedisCounter) Get(ctx context.Context) (int64, error) {
 	return c.client.Get(ctx, c.key).Int64()
 }
@@ -1,4 +1,4 @@
-package main
+package dedup
 
 import (
 	"context"
@@ -11,8 +11,14 @@ var _ ProcessMarker = (*RedisMarker)(nil)
 
 type RedisMarker struct {
 	client redis.UniversalClient
+	ttl    time.Duration
+}
+
+// NewRedisMarker ttlを過ぎたマークは消える
+func NewRedisMarker(client redis.UniversalClient, ttl time.Duration) *RedisMarker {
+	return &RedisMarker{client: client, ttl: ttl}
 }
 
 func (c *RedisMarker) Acquire(ctx context.Context, msgID string) (bool, error) {
-	return c.client.SetNX(ctx, "subscriber-processed-check:"+msgID, "v", time.Second*60).Result()
+	return c.client.SetNX(ctx, "subscriber-processed-check:"+msgID, "v", c.ttl).Result()
 }
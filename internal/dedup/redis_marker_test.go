@@ -0,0 +1,73 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisMarker(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	cl := NewRedisClient(s.Addr(), 1)
+	defer cl.Close()
+	m := NewRedisMarker(cl, 10*time.Second)
+
+	for _, tt := range []struct {
+		msgID string
+		want  bool
+	}{
+		{"a", true},
+		{"a", false},
+		{"b", true},
+	} {
+		got, err := m.Acquire(ctx, tt.msgID)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Acquire(%s)=%t, want %t", tt.msgID, got, tt.want)
+		}
+	}
+
+	if ttl := s.TTL("subscriber-processed-check:a"); ttl != 10*time.Second {
+		t.Errorf("TTL=%s, want 10s", ttl)
+	}
+
+	s.FastForward(9 * time.Second)
+	if got, _ := m.Acquire(ctx, "a"); got {
+		t.Errorf("Acquire(a) before ttl=true, want false")
+	}
+	s.FastForward(time.Second)
+	if got, _ := m.Acquire(ctx, "a"); !got {
+		t.Errorf("Acquire(a) after ttl=false, want true")
+	}
+
+	s.SetError("down")
+	if _, err := m.Acquire(ctx, "c"); err == nil {
+		t.Errorf("Acquire must fail when redis fails")
+	}
+}
+
+func TestRedisCounter(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	cl := NewRedisClient(s.Addr(), 1)
+	defer cl.Close()
+	c := NewRedisCounter(cl, "counter")
+
+	for i := int64(1); i <= 3; i++ {
+		got, err := c.Up(ctx)
+		if err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		if got != i {
+			t.Errorf("Up=%d, want %d", got, i)
+		}
+	}
+	if got, err := c.Get(ctx); err != nil || got != 3 {
+		t.Errorf("Get=%d, %v, want 3", got, err)
+	}
+}
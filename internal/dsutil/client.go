@@ -0,0 +1,116 @@
+package dsutil
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/datastore"
+)
+
+// EmulatorHostEnv datastoreのクライアントはこれが設定されているとエミュレータに接続する
+const EmulatorHostEnv = "DATASTORE_EMULATOR_HOST"
+
+// emulatorProjectID エミュレータはどのproject IDでも受け付けるのでPROJECT_IDがない場合に使う
+const emulatorProjectID = "emulator-project"
+
+// ClientOptions datastoreのクライアントの接続先を指定するフラグ
+type ClientOptions struct {
+	Emulator      *string
+	EmulatorReset *bool
+	Database      *string
+
+	// resetOnce 複数のクライアントを作っても消すのは最初の1回だけ
+	resetOnce sync.Once
+	resetErr  error
+}
+
+// NewClientOptions fsにフラグを登録する。fs.Parseより前に呼ぶこと
+func NewClientOptions(fs *flag.FlagSet) *ClientOptions {
+	return &ClientOptions{
+		Emulator:      fs.String("emulator", "", "host:port of the datastore emulator, overrides "+EmulatorHostEnv),
+		EmulatorReset: fs.Bool("emulator-reset", false, "remove all data in the emulator before running"),
+		Database:      fs.String("database", "", "database ID [empty = default database, or db: of the key]"),
+	}
+}
+
+// EmulatorHost エミュレータを使わないなら空
+func (o *ClientOptions) EmulatorHost() string {
+	if *o.Emulator != "" {
+		return *o.Emulator
+	}
+	return os.Getenv(EmulatorHostEnv)
+}
+
+// DatabaseOf --databaseとキーのdb:で指定されたデータベース。どちらもあれば一致していること
+func (o *ClientOptions) DatabaseOf(fromKey string) (string, error) {
+	switch {
+	case *o.Database == "":
+		return fromKey, nil
+	case fromKey == "" || fromKey == *o.Database:
+		return *o.Database, nil
+	default:
+		return "", fmt.Errorf("--database=%s conflicts with db:%s of the key", *o.Database, fromKey)
+	}
+}
+
+// ProjectID 環境変数PROJECT_ID。エミュレータを使うときは未設定でもよい
+func (o *ClientOptions) ProjectID() string {
+	pjID := os.Getenv("PROJECT_ID")
+	if pjID == "" && o.EmulatorHost() != "" {
+		return emulatorProjectID
+	}
+	return pjID
+}
+
+// NewClient projectのdatabaseに接続する。projectが空ならProjectID、databaseが空ならデフォルトのデータベース
+func (o *ClientOptions) NewClient(ctx context.Context, project, database string) (*datastore.Client, error) {
+	if project == "" {
+		project = o.ProjectID()
+	}
+
+	host := o.EmulatorHost()
+	if host != "" {
+		// クライアントは環境変数しか見ないのでフラグの指定を反映する
+		if err := os.Setenv(EmulatorHostEnv, host); err != nil {
+			return nil, fmt.Errorf("os.Setenv: %w", err)
+		}
+		if *o.EmulatorReset {
+			o.resetOnce.Do(func() {
+				o.resetErr = ResetEmulator(ctx, host)
+			})
+			if o.resetErr != nil {
+				return nil, o.resetErr
+			}
+		}
+	} else if *o.EmulatorReset {
+		return nil, errors.New("--emulator-reset requires the emulator")
+	}
+
+	cl, err := datastore.NewClientWithDatabase(ctx, project, database)
+	if err != nil {
+		return nil, fmt.Errorf("datastore.NewClientWithDatabase: %w", err)
+	}
+	return cl, nil
+}
+
+// ResetEmulator エミュレータの全データを消す
+func ResetEmulator(ctx context.Context, host string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+host+"/reset", nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("reset emulator: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("reset emulator: %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+// Package dstest datastoreのコマンドをエミュレータに対して動かすテストの補助。
+// DATASTORE_EMULATOR_HOSTが設定されていなければgcloudでエミュレータを起動し、
+// どちらもなければテストはスキップする
+package dstest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/tckz/go-gcp-playground/internal/dsutil"
+)
+
+// runMainEnv これが設定されていればテストのバイナリはテストではなくコマンドのmainを実行する
+const runMainEnv = "DSTEST_RUN_MAIN"
+
+// ProjectID エミュレータなのでどのproject IDでもよい
+const ProjectID = "dstest"
+
+// Database --databaseのケースで使うデータベース
+const Database = "dstest-db"
+
+// startTimeout エミュレータの起動を待つ上限
+const startTimeout = time.Minute
+
+// Main TestMainから呼ぶ。Runで起動されたときはmainを実行する。
+// DATASTORE_EMULATOR_HOSTがなくgcloudがあればテストの間だけエミュレータを起動する
+func Main(m *testing.M, main func()) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+
+	stop := func() {}
+	if os.Getenv(dsutil.EmulatorHostEnv) == "" {
+		if gcloud, err := exec.LookPath("gcloud"); err == nil {
+			s, err := startEmulator(gcloud)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "*** dstest: %v\n", err)
+				os.Exit(1)
+			}
+			stop = s
+		}
+	}
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+var reEmulatorHost = regexp.MustCompile(dsutil.EmulatorHostEnv + `=(\S+)`)
+
+// startEmulator 空いているポートでエミュレータを起動し、DATASTORE_EMULATOR_HOSTを設定する。
+// 戻り値はエミュレータを止める関数
+func startEmulator(gcloud string) (func(), error) {
+	cmd := exec.Command(gcloud, "beta", "emulators", "datastore", "start",
+		"--no-store-on-disk", "--consistency=1.0", "--host-port=localhost:0")
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %w", cmd, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cmd.Wait()
+		pw.Close()
+	}()
+	stop := func() {
+		if host := os.Getenv(dsutil.EmulatorHostEnv); host != "" {
+			if res, err := http.Post("http://"+host+"/shutdown", "", nil); err == nil {
+				res.Body.Close()
+			}
+		}
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+			<-done
+		}
+	}
+
+	// 起動のメッセージからホストを拾い、残りの出力は読み捨てる
+	found := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(pr)
+		for sc.Scan() {
+			if m := reEmulatorHost.FindStringSubmatch(sc.Text()); m != nil {
+				select {
+				case found <- m[1]:
+				default:
+				}
+			}
+		}
+		io.Copy(io.Discard, pr)
+	}()
+
+	var host string
+	select {
+	case host = <-found:
+	case <-done:
+		return nil, fmt.Errorf("%s exited before the emulator started", cmd)
+	case <-time.After(startTimeout):
+		cmd.Process.Kill()
+		<-done
+		return nil, fmt.Errorf("%s did not report %s in %s", cmd, dsutil.EmulatorHostEnv, startTimeout)
+	}
+	os.Setenv(dsutil.EmulatorHostEnv, host)
+
+	deadline := time.Now().Add(startTimeout)
+	for {
+		res, err := http.Get("http://" + host + "/")
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return stop, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, fmt.Errorf("emulator at %s is not ready in %s: %v", host, startTimeout, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// SkipWithoutEmulator エミュレータがなければスキップする
+func SkipWithoutEmulator(t *testing.T) {
+	t.Helper()
+	if os.Getenv(dsutil.EmulatorHostEnv) == "" {
+		t.Skipf("neither %s nor gcloud is available", dsutil.EmulatorHostEnv)
+	}
+}
+
+// Client databaseが空ならデフォルトのデータベース
+func Client(t *testing.T, database string) *datastore.Client {
+	t.Helper()
+	SkipWithoutEmulator(t)
+	cl, err := datastore.NewClientWithDatabase(context.Background(), ProjectID, database)
+	if err != nil {
+		t.Fatalf("datastore.NewClientWithDatabase: %v", err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+// Unique 並行して動く他のテストとぶつからないkindやnamespaceの名前
+func Unique(prefix string) string {
+	return fmt.Sprintf("%s%08x", prefix, rand.Uint32())
+}
+
+// Run コマンドをargsで実行してstdoutを返す。終了コードが0以外ならerr
+func Run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	SkipWithoutEmulator(t)
+	cmd := exec.Command(os.Args[0], append([]string{"--log-level", "warn"}, args...)...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1", "PROJECT_ID="+ProjectID)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		t.Logf("stderr of %v:\n%s", args, stderr.String())
+	}
+	return stdout.String(), err
+}
+
+// Case 名前空間とデータベースの組み合わせ
+type Case struct {
+	Name      string
+	Namespace string
+	Database  string
+}
+
+// Cases デフォルトのnamespace、名前付きのnamespace、--databaseを指定したもの
+func Cases() []Case {
+	return []Case{
+		{Name: "default namespace"},
+		{Name: "named namespace", Namespace: Unique("ns")},
+		{Name: "database", Database: Database},
+		{Name: "named namespace in database", Namespace: Unique("ns"), Database: Database},
+	}
+}
+
+// Key cのnamespaceのキー
+func (c Case) Key(kind, name string, parent *datastore.Key) *datastore.Key {
+	k := datastore.NameKey(kind, name, parent)
+	k.Namespace = c.Namespace
+	return k
+}
+
+// KeyString --keyに渡す形式
+func (c Case) KeyString(k *datastore.Key) string {
+	return dsutil.FormatKey(k, c.Database)
+}
+
+// Args --nsと--databaseのうち指定のあるもの
+func (c Case) Args() []string {
+	var ret []string
+	if c.Namespace != "" {
+		ret = append(ret, "--ns", c.Namespace)
+	}
+	if c.Database != "" {
+		ret = append(ret, "--database", c.Database)
+	}
+	return ret
+}
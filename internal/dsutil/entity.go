@@ -0,0 +1,281 @@
+package dsutil
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Entity datastoreのREST APIのEntityに倣ったJSON表現。プロパティの型を落とさずに出力できる
+type Entity struct {
+	Key        *Key              `json:"key,omitempty"`
+	Properties map[string]*Value `json:"properties,omitempty"`
+}
+
+type PartitionID struct {
+	ProjectID   string `json:"projectId,omitempty"`
+	DatabaseID  string `json:"databaseId,omitempty"`
+	NamespaceID string `json:"namespaceId,omitempty"`
+}
+
+type PathElement struct {
+	Kind string `json:"kind"`
+	// ID int64はJSONの数値だと精度が落ちる処理系があるのでREST APIと同じく文字列にする
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Key 祖先から順にpathを並べる
+type Key struct {
+	PartitionID *PartitionID  `json:"partitionId,omitempty"`
+	Path        []PathElement `json:"path"`
+}
+
+type LatLng struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type ArrayValue struct {
+	Values []*Value `json:"values"`
+}
+
+// Value いずれか1つのフィールドだけが値を持つ
+type Value struct {
+	NullValue          *string     `json:"nullValue,omitempty"`
+	BooleanValue       *bool       `json:"booleanValue,omitempty"`
+	IntegerValue       *string     `json:"integerValue,omitempty"`
+	DoubleValue        *float64    `json:"doubleValue,omitempty"`
+	TimestampValue     *string     `json:"timestampValue,omitempty"`
+	KeyValue           *Key        `json:"keyValue,omitempty"`
+	StringValue        *string     `json:"stringValue,omitempty"`
+	BlobValue          *[]byte     `json:"blobValue,omitempty"`
+	GeoPointValue      *LatLng     `json:"geoPointValue,omitempty"`
+	EntityValue        *Entity     `json:"entityValue,omitempty"`
+	ArrayValue         *ArrayValue `json:"arrayValue,omitempty"`
+	ExcludeFromIndexes bool        `json:"excludeFromIndexes,omitempty"`
+}
+
+const nullValue = "NULL_VALUE"
+
+// NewKey kがnilならnil
+func NewKey(k *datastore.Key) *Key {
+	if k == nil {
+		return nil
+	}
+
+	var path []PathElement
+	for e := k; e != nil; e = e.Parent {
+		pe := PathElement{Kind: e.Kind, Name: e.Name}
+		if e.ID != 0 {
+			pe.ID = strconv.FormatInt(e.ID, 10)
+		}
+		path = append([]PathElement{pe}, path...)
+	}
+
+	ret := &Key{Path: path}
+	if k.Namespace != "" {
+		ret.PartitionID = &PartitionID{NamespaceID: k.Namespace}
+	}
+	return ret
+}
+
+// NewKeyRef NewKeyにデータベースを加えたもの
+func NewKeyRef(r KeyRef) *Key {
+	ret := NewKey(r.Key)
+	if ret == nil || r.Database == "" {
+		return ret
+	}
+	if ret.PartitionID == nil {
+		ret.PartitionID = &PartitionID{}
+	}
+	ret.PartitionID.DatabaseID = r.Database
+	return ret
+}
+
+// NewEntity keyがnilの場合はkeyを出力しない
+func NewEntity(key *datastore.Key, props []datastore.Property) (*Entity, error) {
+	ret := &Entity{
+		Key:        NewKey(key),
+		Properties: make(map[string]*Value, len(props)),
+	}
+	for _, p := range props {
+		v, err := NewValue(p.Value, p.NoIndex)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", p.Name, err)
+		}
+		ret.Properties[p.Name] = v
+	}
+	return ret, nil
+}
+
+// NewValue datastore.Propertyの値をValueにする
+func NewValue(v interface{}, noIndex bool) (*Value, error) {
+	ret := &Value{ExcludeFromIndexes: noIndex}
+	switch v := v.(type) {
+	case nil:
+		s := nullValue
+		ret.NullValue = &s
+	case bool:
+		ret.BooleanValue = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		ret.IntegerValue = &s
+	case float64:
+		ret.DoubleValue = &v
+	case time.Time:
+		s := v.UTC().Format(time.RFC3339Nano)
+		ret.TimestampValue = &s
+	case *datastore.Key:
+		ret.KeyValue = NewKey(v)
+	case string:
+		ret.StringValue = &v
+	case []byte:
+		ret.BlobValue = &v
+	case datastore.GeoPoint:
+		ret.GeoPointValue = &LatLng{Latitude: v.Lat, Longitude: v.Lng}
+	case *datastore.Entity:
+		e, err := NewEntity(v.Key, v.Properties)
+		if err != nil {
+			return nil, err
+		}
+		ret.EntityValue = e
+	case []interface{}:
+		// 配列の要素自体にはnoindexの指定がないのでプロパティの指定を各要素に付ける
+		arr := &ArrayValue{Values: make([]*Value, 0, len(v))}
+		for _, e := range v {
+			ev, err := NewValue(e, noIndex)
+			if err != nil {
+				return nil, err
+			}
+			arr.Values = append(arr.Values, ev)
+		}
+		ret.ArrayValue = arr
+		ret.ExcludeFromIndexes = false
+	default:
+		return nil, fmt.Errorf("unsupported value type: %T", v)
+	}
+	return ret, nil
+}
+
+// DatastoreKey NewKeyの逆。データベースは返さない
+func (k *Key) DatastoreKey() (*datastore.Key, error) {
+	if k == nil {
+		return nil, nil
+	}
+	if len(k.Path) == 0 {
+		return nil, errors.New("empty key path")
+	}
+
+	var ns string
+	if k.PartitionID != nil {
+		ns = k.PartitionID.NamespaceID
+	}
+	var ret *datastore.Key
+	for i, e := range k.Path {
+		if e.Kind == "" {
+			return nil, errors.New("empty kind in key path")
+		}
+		if ret != nil && ret.Incomplete() {
+			return nil, fmt.Errorf("incomplete ancestor in key path at %d", i)
+		}
+		ret = &datastore.Key{Kind: e.Kind, Name: e.Name, Parent: ret, Namespace: ns}
+		if e.ID != "" {
+			id, err := strconv.ParseInt(e.ID, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("strconv.ParseInt: %w", err)
+			}
+			ret.ID = id
+		}
+	}
+	return ret, nil
+}
+
+// PropertyList NewEntityの逆。プロパティ名の順に並べる
+func (e *Entity) PropertyList() (datastore.PropertyList, error) {
+	names := make([]string, 0, len(e.Properties))
+	for k := range e.Properties {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	ret := make([]datastore.Property, 0, len(names))
+	for _, name := range names {
+		v := e.Properties[name]
+		iv, err := v.Interface()
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", name, err)
+		}
+		ret = append(ret, datastore.Property{Name: name, Value: iv, NoIndex: v.NoIndex()})
+	}
+	return ret, nil
+}
+
+// NoIndex 配列は要素のどれかがインデックスから除外されていればtrue
+func (v *Value) NoIndex() bool {
+	if v.ArrayValue != nil {
+		for _, e := range v.ArrayValue.Values {
+			if e.ExcludeFromIndexes {
+				return true
+			}
+		}
+	}
+	return v.ExcludeFromIndexes
+}
+
+// Interface datastore.Propertyの値にする。NewValueの逆
+func (v *Value) Interface() (interface{}, error) {
+	switch {
+	case v == nil || v.NullValue != nil:
+		return nil, nil
+	case v.BooleanValue != nil:
+		return *v.BooleanValue, nil
+	case v.IntegerValue != nil:
+		n, err := strconv.ParseInt(*v.IntegerValue, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("strconv.ParseInt: %w", err)
+		}
+		return n, nil
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, nil
+	case v.TimestampValue != nil:
+		t, err := time.Parse(time.RFC3339Nano, *v.TimestampValue)
+		if err != nil {
+			return nil, fmt.Errorf("time.Parse: %w", err)
+		}
+		return t, nil
+	case v.KeyValue != nil:
+		return v.KeyValue.DatastoreKey()
+	case v.StringValue != nil:
+		return *v.StringValue, nil
+	case v.BlobValue != nil:
+		return *v.BlobValue, nil
+	case v.GeoPointValue != nil:
+		return datastore.GeoPoint{Lat: v.GeoPointValue.Latitude, Lng: v.GeoPointValue.Longitude}, nil
+	case v.EntityValue != nil:
+		key, err := v.EntityValue.Key.DatastoreKey()
+		if err != nil {
+			return nil, err
+		}
+		props, err := v.EntityValue.PropertyList()
+		if err != nil {
+			return nil, err
+		}
+		return &datastore.Entity{Key: key, Properties: props}, nil
+	case v.ArrayValue != nil:
+		ret := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, e := range v.ArrayValue.Values {
+			iv, err := e.Interface()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, iv)
+		}
+		return ret, nil
+	}
+	return nil, errors.New("value has no type")
+}
@@ -0,0 +1,70 @@
+package dsutil
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestNewKeyRefJSON(t *testing.T) {
+	inNS := datastore.NameKey("Kind", "k", nil)
+	inNS.Namespace = "ns1"
+
+	tests := []struct {
+		name string
+		ref  KeyRef
+		want string
+	}{
+		{
+			name: "default namespace",
+			ref:  KeyRef{Key: datastore.IDKey("Kind", 1, nil)},
+			want: `{"path":[{"kind":"Kind","id":"1"}]}`,
+		},
+		{
+			name: "default namespace with database",
+			ref:  KeyRef{Database: "db1", Key: datastore.IDKey("Kind", 1, nil)},
+			want: `{"partitionId":{"databaseId":"db1"},"path":[{"kind":"Kind","id":"1"}]}`,
+		},
+		{
+			name: "namespace with database",
+			ref:  KeyRef{Database: "db1", Key: inNS},
+			want: `{"partitionId":{"databaseId":"db1","namespaceId":"ns1"},"path":[{"kind":"Kind","name":"k"}]}`,
+		},
+		{
+			name: "nil key",
+			ref:  KeyRef{Database: "db1"},
+			want: `null`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(NewKeyRef(tt.ref))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("got=%s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+// datastore-get/datastore-queryの出力と同じくエンティティのキーをデータベース付きにする
+func TestEntityWithDatabase(t *testing.T) {
+	key := datastore.NameKey("Kind", "k", nil)
+	ent, err := NewEntity(key, datastore.PropertyList{{Name: "a", Value: "x"}})
+	if err != nil {
+		t.Fatalf("NewEntity: %v", err)
+	}
+	ent.Key = NewKeyRef(KeyRef{Database: "db1", Key: key})
+
+	b, err := json.Marshal(ent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"key":{"partitionId":{"databaseId":"db1"},"path":[{"kind":"Kind","name":"k"}]},"properties":{"a":{"stringValue":"x"}}}`
+	if string(b) != want {
+		t.Errorf("got=%s, want %s", b, want)
+	}
+}
@@ -0,0 +1,301 @@
+package dsutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+var operators = map[string]string{
+	"=":      "=",
+	"==":     "=",
+	"<":      "<",
+	"<=":     "<=",
+	">":      ">",
+	">=":     ">=",
+	"!=":     "!=",
+	"in":     "in",
+	"not-in": "not-in",
+	"not_in": "not-in",
+}
+
+// normalizeOperator IN, NOT_INのような書き方もdatastoreのパッケージの書き方にする
+func normalizeOperator(op string) (string, error) {
+	v, ok := operators[strings.ToLower(op)]
+	if !ok {
+		return "", fmt.Errorf("unknown operator: %s", op)
+	}
+	return v, nil
+}
+
+// ParseFilter "PROP OP VALUE"の形のフィルタを解釈する。VALUEの書式はParseLiteralを参照
+func ParseFilter(s string) (datastore.PropertyFilter, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return datastore.PropertyFilter{}, fmt.Errorf("filter must be PROP OP VALUE: %s", s)
+	}
+	op, err := normalizeOperator(fields[1])
+	if err != nil {
+		return datastore.PropertyFilter{}, err
+	}
+
+	// VALUEは空白を含みうるので元の文字列から切り出す
+	rest := strings.TrimSpace(s)
+	for _, f := range fields[:2] {
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, f))
+	}
+	v, err := ParseLiteral(rest)
+	if err != nil {
+		return datastore.PropertyFilter{}, fmt.Errorf("%s: %w", s, err)
+	}
+	if (op == "in" || op == "not-in") == !isArray(v) {
+		return datastore.PropertyFilter{}, fmt.Errorf("value of %s must be an array only for IN and NOT_IN: %s", op, s)
+	}
+
+	return datastore.PropertyFilter{FieldName: fields[0], Operator: op, Value: v}, nil
+}
+
+func isArray(v interface{}) bool {
+	_, ok := v.([]interface{})
+	return ok
+}
+
+// splitTopLevel カッコとクォートの外にあるsepで分割する。カッコやクォートが閉じていなければエラー
+func splitTopLevel(s string, sep byte) ([]string, error) {
+	var ret []string
+	depth := 0
+	var quote byte
+	begin := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced %c at %d: %s", c, i, s)
+			}
+		case c == sep && depth == 0:
+			ret = append(ret, s[begin:i])
+			begin = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c: %s", quote, s)
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses: %s", s)
+	}
+	return append(ret, s[begin:]), nil
+}
+
+func unwrap(s, prefix string) (string, bool) {
+	if strings.HasPrefix(s, prefix+"(") && strings.HasSuffix(s, ")") {
+		return strings.TrimSpace(s[len(prefix)+1 : len(s)-1]), true
+	}
+	return "", false
+}
+
+// ParseLiteral フィルタの値を解釈する
+//
+//	"str" 'str'         文字列
+//	123 -1              整数
+//	1.5 1e3             浮動小数点数
+//	true false null     真偽値、null
+//	time(RFC3339)       日時
+//	key(KEY)            キー式かエンコード済みのキー
+//	geo(LAT,LNG)        地点
+//	blob(BASE64)        バイト列
+//	[v1, v2, ...]       配列。IN, NOT_INで使う
+//
+// いずれにも当てはまらないものはそのまま文字列とみなす
+func ParseLiteral(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("empty value")
+	}
+
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		body := strings.TrimSpace(s[1 : len(s)-1])
+		ret := []interface{}{}
+		if body == "" {
+			return ret, nil
+		}
+		elems, err := splitTopLevel(body, ',')
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range elems {
+			v, err := ParseLiteral(e)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	}
+	if v, ok := unwrap(s, "time"); ok {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("time.Parse: %w", err)
+		}
+		return t, nil
+	}
+	if v, ok := unwrap(s, "key"); ok {
+		r, err := ParseKey(v)
+		if err != nil {
+			return nil, err
+		}
+		return r.Key, nil
+	}
+	if v, ok := unwrap(s, "geo"); ok {
+		latlng := strings.Split(v, ",")
+		if len(latlng) != 2 {
+			return nil, fmt.Errorf("geo must be geo(LAT,LNG): %s", s)
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(latlng[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("strconv.ParseFloat: %w", err)
+		}
+		lng, err := strconv.ParseFloat(strings.TrimSpace(latlng[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("strconv.ParseFloat: %w", err)
+		}
+		return datastore.GeoPoint{Lat: lat, Lng: lng}, nil
+	}
+	if v, ok := unwrap(s, "blob"); ok {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("base64.DecodeString: %w", err)
+		}
+		return b, nil
+	}
+	if strings.HasPrefix(s, "[") {
+		return nil, fmt.Errorf("unbalanced [: %s", s)
+	}
+	for _, f := range []string{"time", "key", "geo", "blob"} {
+		if strings.HasPrefix(s, f+"(") {
+			return nil, fmt.Errorf("%s must be %s(...): %s", f, f, s)
+		}
+	}
+
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		var v string
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %w", err)
+		}
+		return v, nil
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return s[1 : len(s)-1], nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
+
+// Filter --filter-jsonで受け付けるフィルタ。and, or, propertyのいずれか1つを指定する。
+// valueはEntityのプロパティと同じ型付きの表現
+type Filter struct {
+	And      []*Filter `json:"and,omitempty"`
+	Or       []*Filter `json:"or,omitempty"`
+	Property string    `json:"property,omitempty"`
+	Op       string    `json:"op,omitempty"`
+	Value    *Value    `json:"value,omitempty"`
+}
+
+func (f *Filter) EntityFilter() (datastore.EntityFilter, error) {
+	sub := func(fs []*Filter) ([]datastore.EntityFilter, error) {
+		ret := make([]datastore.EntityFilter, 0, len(fs))
+		for _, e := range fs {
+			ef, err := e.EntityFilter()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, ef)
+		}
+		return ret, nil
+	}
+
+	switch {
+	case len(f.And) > 0:
+		fs, err := sub(f.And)
+		if err != nil {
+			return nil, err
+		}
+		return datastore.AndFilter{Filters: fs}, nil
+	case len(f.Or) > 0:
+		fs, err := sub(f.Or)
+		if err != nil {
+			return nil, err
+		}
+		return datastore.OrFilter{Filters: fs}, nil
+	case f.Property != "":
+		op, err := normalizeOperator(f.Op)
+		if err != nil {
+			return nil, err
+		}
+		v, err := f.Value.Interface()
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", f.Property, err)
+		}
+		return datastore.PropertyFilter{FieldName: f.Property, Operator: op, Value: v}, nil
+	}
+	return nil, errors.New("filter must have and, or or property")
+}
+
+// ParseFilterJSON JSONで書かれたFilterを解釈する
+func ParseFilterJSON(s string) (datastore.EntityFilter, error) {
+	var f Filter
+	if err := json.Unmarshal([]byte(s), &f); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return f.EntityFilter()
+}
+
+// FiltersFlag 複数回指定できる--filter
+type FiltersFlag struct {
+	values  []string
+	Filters []datastore.PropertyFilter
+}
+
+func (f *FiltersFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *FiltersFlag) Set(s string) error {
+	pf, err := ParseFilter(s)
+	if err != nil {
+		return err
+	}
+	f.values = append(f.values, s)
+	f.Filters = append(f.Filters, pf)
+	return nil
+}
@@ -0,0 +1,218 @@
+package dsutil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    datastore.PropertyFilter
+		wantErr string
+	}{
+		{in: "A = 1", want: datastore.PropertyFilter{FieldName: "A", Operator: "=", Value: int64(1)}},
+		{in: "A == 1", want: datastore.PropertyFilter{FieldName: "A", Operator: "=", Value: int64(1)}},
+		{in: "A < 1", want: datastore.PropertyFilter{FieldName: "A", Operator: "<", Value: int64(1)}},
+		{in: "A <= 1", want: datastore.PropertyFilter{FieldName: "A", Operator: "<=", Value: int64(1)}},
+		{in: "A > 1", want: datastore.PropertyFilter{FieldName: "A", Operator: ">", Value: int64(1)}},
+		{in: "A >= 1", want: datastore.PropertyFilter{FieldName: "A", Operator: ">=", Value: int64(1)}},
+		{in: "A != 1", want: datastore.PropertyFilter{FieldName: "A", Operator: "!=", Value: int64(1)}},
+		{in: "A IN [1, 2]", want: datastore.PropertyFilter{FieldName: "A", Operator: "in", Value: []interface{}{int64(1), int64(2)}}},
+		{in: "A not-in [1]", want: datastore.PropertyFilter{FieldName: "A", Operator: "not-in", Value: []interface{}{int64(1)}}},
+		{in: "A NOT_IN []", want: datastore.PropertyFilter{FieldName: "A", Operator: "not-in", Value: []interface{}{}}},
+		// VALUEの空白は保たれる
+		{in: `  Name   =   "a  b"  `, want: datastore.PropertyFilter{FieldName: "Name", Operator: "=", Value: "a  b"}},
+		{in: "A = 'x, (y)'", want: datastore.PropertyFilter{FieldName: "A", Operator: "=", Value: "x, (y)"}},
+		{in: "A =", wantErr: "filter must be PROP OP VALUE"},
+		{in: "A", wantErr: "filter must be PROP OP VALUE"},
+		{in: "A ~ 1", wantErr: "unknown operator: ~"},
+		{in: "A IN 1", wantErr: "must be an array only for IN and NOT_IN"},
+		{in: "A = [1]", wantErr: "must be an array only for IN and NOT_IN"},
+		{in: "A IN [1, (2]", wantErr: "A IN [1, (2]: unbalanced parentheses"},
+		{in: "A = time(yesterday)", wantErr: "time.Parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFilter(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFilter: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got=%#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLiteral(t *testing.T) {
+	parent := datastore.NameKey("P", "p", nil)
+	tests := []struct {
+		in      string
+		want    interface{}
+		wantErr string
+	}{
+		{in: `"a\"b"`, want: `a"b`},
+		{in: `'a"b'`, want: `a"b`},
+		{in: `"123"`, want: "123"},
+		{in: "bare", want: "bare"},
+		{in: "123", want: int64(123)},
+		{in: "-1", want: int64(-1)},
+		{in: "1.5", want: 1.5},
+		{in: "1e3", want: 1000.0},
+		{in: "true", want: true},
+		{in: "false", want: false},
+		{in: "null", want: nil},
+		{in: "time(2024-01-02T03:04:05.5Z)", want: time.Date(2024, 1, 2, 3, 4, 5, 500000000, time.UTC)},
+		{in: "key(P/'p'/C/1)", want: datastore.IDKey("C", 1, parent)},
+		{in: "key(" + datastore.IDKey("C", 1, parent).Encode() + ")", want: datastore.IDKey("C", 1, parent)},
+		{in: "geo(35.5, -139)", want: datastore.GeoPoint{Lat: 35.5, Lng: -139}},
+		{in: "blob(AAEC)", want: []byte{0, 1, 2}},
+		{in: "[]", want: []interface{}{}},
+		// カッコとクォートの中のカンマでは分割しない
+		{
+			in:   `[geo(1,2), "a,b", 'c]d', [1, 2], key(P/'x,y')]`,
+			want: []interface{}{datastore.GeoPoint{Lat: 1, Lng: 2}, "a,b", "c]d", []interface{}{int64(1), int64(2)}, datastore.NameKey("P", "x,y", nil)},
+		},
+		{in: "", wantErr: "empty value"},
+		{in: "[1, ]", wantErr: "empty value"},
+		{in: `"a`, want: `"a`},
+		{in: `["a]`, wantErr: `unterminated "`},
+		{in: "[1, 2", wantErr: "unbalanced ["},
+		{in: "[(1]", wantErr: "unbalanced parentheses"},
+		{in: "[1)]", wantErr: "unbalanced ) at 1"},
+		{in: "time(2024-01-02", wantErr: "time must be time(...)"},
+		{in: "time(2024-01-02)", wantErr: "time.Parse"},
+		{in: "key(P/'p'/C)", want: datastore.IncompleteKey("C", parent)},
+		{in: "key(/x)", wantErr: "empty kind"},
+		{in: "geo(1)", wantErr: "geo must be geo(LAT,LNG)"},
+		{in: "geo(a,1)", wantErr: "strconv.ParseFloat"},
+		{in: "blob(!)", wantErr: "base64.DecodeString"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLiteral(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLiteral: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got=%#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTopLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr string
+	}{
+		{in: "", want: []string{""}},
+		{in: "a,b", want: []string{"a", "b"}},
+		{in: "f(a,b),[c,(d,e)],g", want: []string{"f(a,b)", "[c,(d,e)]", "g"}},
+		{in: `"a,b",'c,d'`, want: []string{`"a,b"`, `'c,d'`}},
+		{in: `"a\",b",c`, want: []string{`"a\",b"`, "c"}},
+		{in: `"(",')'`, want: []string{`"("`, `')'`}},
+		{in: "a,", want: []string{"a", ""}},
+		{in: "(a,b", wantErr: "unbalanced parentheses"},
+		{in: "a),b", wantErr: "unbalanced ) at 1"},
+		{in: `'a,b`, wantErr: "unterminated '"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := splitTopLevel(tt.in, ',')
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitTopLevel: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got=%q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterEntityFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    datastore.EntityFilter
+		wantErr string
+	}{
+		{
+			name: "property",
+			in:   `{"property": "A", "op": "IN", "value": {"arrayValue": {"values": [{"integerValue": "1"}, {"stringValue": "x"}]}}}`,
+			want: datastore.PropertyFilter{FieldName: "A", Operator: "in", Value: []interface{}{int64(1), "x"}},
+		},
+		{
+			name: "timestamp and key",
+			in: `{"and": [
+				{"property": "T", "op": ">=", "value": {"timestampValue": "2024-01-02T03:04:05Z"}},
+				{"property": "K", "op": "=", "value": {"keyValue": {"path": [{"kind": "P", "name": "p"}]}}}
+			]}`,
+			want: datastore.AndFilter{Filters: []datastore.EntityFilter{
+				datastore.PropertyFilter{FieldName: "T", Operator: ">=", Value: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+				datastore.PropertyFilter{FieldName: "K", Operator: "=", Value: datastore.NameKey("P", "p", nil)},
+			}},
+		},
+		{
+			name: "nested",
+			in: `{"or": [
+				{"property": "A", "op": "=", "value": {"nullValue": "NULL_VALUE"}},
+				{"and": [{"property": "B", "op": "<", "value": {"doubleValue": 1.5}}, {"property": "C", "op": "!=", "value": {"booleanValue": true}}]}
+			]}`,
+			want: datastore.OrFilter{Filters: []datastore.EntityFilter{
+				datastore.PropertyFilter{FieldName: "A", Operator: "=", Value: nil},
+				datastore.AndFilter{Filters: []datastore.EntityFilter{
+					datastore.PropertyFilter{FieldName: "B", Operator: "<", Value: 1.5},
+					datastore.PropertyFilter{FieldName: "C", Operator: "!=", Value: true},
+				}},
+			}},
+		},
+		{name: "empty", in: `{}`, wantErr: "filter must have and, or or property"},
+		{name: "empty in and", in: `{"and": [{}]}`, wantErr: "filter must have and, or or property"},
+		{name: "operator", in: `{"property": "A", "op": "~", "value": {"integerValue": "1"}}`, wantErr: "unknown operator: ~"},
+		{name: "value", in: `{"property": "A", "op": "=", "value": {"integerValue": "x"}}`, wantErr: "property A: strconv.ParseInt"},
+		{name: "untyped value", in: `{"property": "A", "op": "=", "value": {}}`, wantErr: "property A: value has no type"},
+		{name: "json", in: `{"property": `, wantErr: "json.Unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilterJSON(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFilterJSON: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got=%#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
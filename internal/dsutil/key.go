@@ -0,0 +1,206 @@
+package dsutil
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// キー式の書式
+//
+//	[db:DATABASE/][ns:NAMESPACE/]Kind/ident[/Kind/ident...][/Kind]
+//
+// identは数字だけならID、それ以外はnameとみなす。数字だけのnameは'123'のようにクォートする。
+// 各要素はパーセントエンコードできるので/を含むnameは%2Fと書く。
+// 末尾がKindだけのものは不完全なキーになる。
+// /を含まずKey.Encodeの結果として読めるものはエンコード済みのキーとして扱う
+const (
+	databasePrefix  = "db:"
+	namespacePrefix = "ns:"
+)
+
+// KeyRef どのデータベースのキーか。datastore.Keyはデータベースを持たないので組にして扱う
+type KeyRef struct {
+	Database string
+	Key      *datastore.Key
+}
+
+func (r KeyRef) String() string {
+	return FormatKey(r.Key, r.Database)
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func unescape(s string) (string, error) {
+	v, err := url.PathUnescape(s)
+	if err != nil {
+		return "", fmt.Errorf("url.PathUnescape: %s: %w", s, err)
+	}
+	return v, nil
+}
+
+// ParseKey キー式かエンコード済みのキーを解釈する
+func ParseKey(s string) (KeyRef, error) {
+	if s == "" {
+		return KeyRef{}, errors.New("empty key")
+	}
+	if !strings.Contains(s, "/") {
+		if k, err := datastore.DecodeKey(s); err == nil {
+			return KeyRef{Key: k}, nil
+		}
+	}
+
+	var ret KeyRef
+	var namespace string
+	elems := strings.Split(s, "/")
+	if strings.HasPrefix(elems[0], databasePrefix) {
+		v, err := unescape(strings.TrimPrefix(elems[0], databasePrefix))
+		if err != nil {
+			return KeyRef{}, err
+		}
+		ret.Database = v
+		elems = elems[1:]
+	}
+	if len(elems) > 0 && strings.HasPrefix(elems[0], namespacePrefix) {
+		v, err := unescape(strings.TrimPrefix(elems[0], namespacePrefix))
+		if err != nil {
+			return KeyRef{}, err
+		}
+		namespace = v
+		elems = elems[1:]
+	}
+	if len(elems) == 0 {
+		return KeyRef{}, fmt.Errorf("no kind in key: %s", s)
+	}
+
+	var key *datastore.Key
+	for i := 0; i < len(elems); i += 2 {
+		kind, err := unescape(elems[i])
+		if err != nil {
+			return KeyRef{}, err
+		}
+		if kind == "" {
+			return KeyRef{}, fmt.Errorf("empty kind in key: %s", s)
+		}
+		if key != nil && key.Incomplete() {
+			return KeyRef{}, fmt.Errorf("incomplete ancestor in key: %s", s)
+		}
+		key = &datastore.Key{Kind: kind, Parent: key, Namespace: namespace}
+		if i+1 >= len(elems) {
+			break
+		}
+
+		ident, err := unescape(elems[i+1])
+		if err != nil {
+			return KeyRef{}, err
+		}
+		switch {
+		case isQuoted(ident):
+			key.Name = ident[1 : len(ident)-1]
+		case isDigits(ident):
+			id, err := strconv.ParseInt(ident, 10, 64)
+			if err != nil {
+				return KeyRef{}, fmt.Errorf("strconv.ParseInt: %w", err)
+			}
+			if id == 0 {
+				return KeyRef{}, fmt.Errorf("id must not be 0: %s", s)
+			}
+			key.ID = id
+		default:
+			key.Name = ident
+		}
+		if key.Incomplete() {
+			return KeyRef{}, fmt.Errorf("empty name in key: %s", s)
+		}
+	}
+	ret.Key = key
+	return ret, nil
+}
+
+// escaper 区切りの/とエスケープの%だけをパーセントエンコードする
+var escaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+func formatName(name string) string {
+	// 数字だけのものやクォートで始まるものはそのままだと別の意味に読まれるのでクォートする
+	if isDigits(name) || strings.HasPrefix(name, "'") || strings.HasPrefix(name, `"`) {
+		name = "'" + name + "'"
+	}
+	return escaper.Replace(name)
+}
+
+func formatKind(kind string) string {
+	v := escaper.Replace(kind)
+	// db:やns:で始まるkindは接頭辞と区別できるよう:をエスケープする
+	if strings.HasPrefix(v, databasePrefix) || strings.HasPrefix(v, namespacePrefix) {
+		v = strings.Replace(v, ":", "%3A", 1)
+	}
+	return v
+}
+
+// FormatKey ParseKeyで読めるキー式にする。databaseが空ならdb:を付けない
+func FormatKey(k *datastore.Key, database string) string {
+	if k == nil {
+		return ""
+	}
+
+	var elems []string
+	for e := k; e != nil; e = e.Parent {
+		switch {
+		case e.ID != 0:
+			elems = append(elems, strconv.FormatInt(e.ID, 10))
+		case e.Name != "":
+			elems = append(elems, formatName(e.Name))
+		}
+		elems = append(elems, formatKind(e.Kind))
+	}
+	if k.Namespace != "" {
+		elems = append(elems, namespacePrefix+escaper.Replace(k.Namespace))
+	}
+	if database != "" {
+		elems = append(elems, databasePrefix+escaper.Replace(database))
+	}
+
+	for i, j := 0, len(elems)-1; i < j; i, j = i+1, j-1 {
+		elems[i], elems[j] = elems[j], elems[i]
+	}
+	return strings.Join(elems, "/")
+}
+
+// KeyFlag キー式を受け付けるフラグ
+type KeyFlag struct {
+	KeyRef
+}
+
+func (f *KeyFlag) String() string {
+	if f == nil || f.Key == nil {
+		return ""
+	}
+	return f.KeyRef.String()
+}
+
+func (f *KeyFlag) Set(s string) error {
+	r, err := ParseKey(s)
+	if err != nil {
+		return err
+	}
+	f.KeyRef = r
+	return nil
+}
@@ -0,0 +1,103 @@
+package dsutil
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+)
+
+// withNamespace 親も含めてnamespaceを付ける
+func withNamespace(k *datastore.Key, ns string) *datastore.Key {
+	for e := k; e != nil; e = e.Parent {
+		e.Namespace = ns
+	}
+	return k
+}
+
+func TestFormatKeyParseKey(t *testing.T) {
+	parent := datastore.NameKey("P", "p", nil)
+	tests := []struct {
+		name     string
+		key      *datastore.Key
+		database string
+		want     string
+	}{
+		{name: "id", key: datastore.IDKey("K", 123, nil), want: "K/123"},
+		{name: "numeric name", key: datastore.NameKey("K", "123", nil), want: "K/'123'"},
+		{name: "name", key: datastore.NameKey("K", "abc", nil), want: "K/abc"},
+		{name: "quoted name", key: datastore.NameKey("K", "'a'", nil), want: "K/''a''"},
+		{name: "double quoted name", key: datastore.NameKey("K", `"a"`, nil), want: `K/'"a"'`},
+		{name: "slash", key: datastore.NameKey("K/x", "a/b", nil), want: "K%2Fx/a%2Fb"},
+		{name: "percent", key: datastore.NameKey("K", "100%25", nil), want: "K/100%2525"},
+		{name: "ancestor", key: datastore.IDKey("C", 1, parent), want: "P/p/C/1"},
+		{name: "incomplete", key: datastore.IncompleteKey("C", parent), want: "P/p/C"},
+		{name: "namespace", key: withNamespace(datastore.IDKey("C", 1, datastore.NameKey("P", "p", nil)), "n/s"), want: "ns:n%2Fs/P/p/C/1"},
+		{name: "database", key: datastore.NameKey("K", "a", nil), database: "db1", want: "db:db1/K/a"},
+		{name: "database and namespace", key: withNamespace(datastore.NameKey("K", "a", nil), "ns1"), database: "db1", want: "db:db1/ns:ns1/K/a"},
+		{name: "kind like db prefix", key: datastore.NameKey("db:x", "a", nil), want: "db%3Ax/a"},
+		{name: "kind like ns prefix", key: datastore.NameKey("ns:x", "ns:y", nil), database: "db1", want: "db:db1/ns%3Ax/ns:y"},
+		{name: "kind like prefix in namespace", key: withNamespace(datastore.NameKey("ns:x", "a", nil), "ns1"), want: "ns:ns1/ns%3Ax/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := FormatKey(tt.key, tt.database)
+			if s != tt.want {
+				t.Errorf("FormatKey=%s, want %s", s, tt.want)
+			}
+			r, err := ParseKey(s)
+			if err != nil {
+				t.Fatalf("ParseKey(%s): %v", s, err)
+			}
+			if !r.Key.Equal(tt.key) || r.Database != tt.database {
+				t.Errorf("ParseKey(%s)=%s in %q, want %s in %q", s, r.Key, r.Database, tt.key, tt.database)
+			}
+		})
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	parent := datastore.NameKey("P", "p", nil)
+	encoded := withNamespace(datastore.IDKey("C", 1, datastore.NameKey("P", "p", nil)), "ns1")
+	tests := []struct {
+		in      string
+		want    *datastore.Key
+		wantDB  string
+		wantErr string
+	}{
+		{in: `K/"123"`, want: datastore.NameKey("K", "123", nil)},
+		{in: "K/a%2Fb", want: datastore.NameKey("K", "a/b", nil)},
+		{in: "db:db1/P/p/C", want: datastore.IncompleteKey("C", parent), wantDB: "db1"},
+		{in: "ns:/K/1", want: datastore.IDKey("K", 1, nil)},
+		{in: encoded.Encode(), want: encoded},
+		{in: "", wantErr: "empty key"},
+		{in: "db:db1", wantErr: "no kind in key"},
+		{in: "db:db1/ns:ns1", wantErr: "no kind in key"},
+		{in: "/1", wantErr: "empty kind in key"},
+		{in: "P/p//1", wantErr: "empty kind in key"},
+		{in: "P//C/1", wantErr: "empty name in key"},
+		{in: "K/''", wantErr: "empty name in key"},
+		{in: "K/", wantErr: "empty name in key"},
+		{in: "K/0", wantErr: "id must not be 0"},
+		{in: "K/99999999999999999999", wantErr: "strconv.ParseInt"},
+		{in: "K/%zz", wantErr: "url.PathUnescape"},
+		{in: "db:%zz/K/1", wantErr: "url.PathUnescape"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := ParseKey(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKey: %v", err)
+			}
+			if !r.Key.Equal(tt.want) || r.Database != tt.wantDB {
+				t.Errorf("got=%s in %q, want %s in %q", r.Key, r.Database, tt.want, tt.wantDB)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package dsutil
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+	datastorepb "google.golang.org/genproto/googleapis/datastore/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QueryOptions どのエンティティを対象にするかを指定するフラグ
+type QueryOptions struct {
+	Kind       *string
+	Namespace  *string
+	Ancestor   *KeyFlag
+	Filters    *FiltersFlag
+	FilterJSON *string
+	Or         *bool
+}
+
+// NewQueryOptions fsにフラグを登録する。fs.Parseより前に呼ぶこと
+func NewQueryOptions(fs *flag.FlagSet) *QueryOptions {
+	o := &QueryOptions{
+		Kind:       fs.String("kind", "mykind", "kind"),
+		Namespace:  fs.String("ns", "", "namespace [empty = namespace of --ancestor]"),
+		Ancestor:   &KeyFlag{},
+		Filters:    &FiltersFlag{},
+		FilterJSON: fs.String("filter-json", "", `{"and"|"or": [filter, ...]} or {"property", "op", "value": typed value}, combined with --filter by AND`),
+		Or:         fs.Bool("or", false, "combine --filter by OR instead of AND"),
+	}
+	fs.Var(o.Ancestor, "ancestor", "[db:DATABASE/][ns:NAMESPACE/]Kind/ident/... or encoded key of ancestor")
+	fs.Var(o.Filters, "filter", `"PROP OP VALUE", OP is =|<|<=|>|>=|!=|IN|NOT_IN, VALUE is "str"|123|1.5|true|null|time(RFC3339)|key(KEY)|geo(LAT,LNG)|blob(BASE64)|[v, ...], can be specified multiple times`)
+	return o
+}
+
+// Database --ancestorにデータベースの指定があればそれを返す
+func (o *QueryOptions) Database() string {
+	return o.Ancestor.Database
+}
+
+// Query フラグからクエリを組み立てる
+func (o *QueryOptions) Query() (*datastore.Query, error) {
+	ns := *o.Namespace
+	if ns == "" && o.Ancestor.Key != nil {
+		ns = o.Ancestor.Key.Namespace
+	}
+
+	q := datastore.NewQuery(*o.Kind).Namespace(ns)
+	if o.Ancestor.Key != nil {
+		if o.Ancestor.Key.Incomplete() {
+			return nil, errors.New("--ancestor must be complete")
+		}
+		q = q.Ancestor(o.Ancestor.Key)
+	}
+
+	var filters []datastore.EntityFilter
+	if len(o.Filters.Filters) > 0 {
+		fs := make([]datastore.EntityFilter, 0, len(o.Filters.Filters))
+		for _, e := range o.Filters.Filters {
+			fs = append(fs, e)
+		}
+		if *o.Or && len(fs) > 1 {
+			filters = append(filters, datastore.OrFilter{Filters: fs})
+		} else {
+			filters = append(filters, fs...)
+		}
+	}
+	if *o.FilterJSON != "" {
+		f, err := ParseFilterJSON(*o.FilterJSON)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	// 複数指定するとANDになる
+	for _, f := range filters {
+		q = q.FilterEntity(f)
+	}
+	return q, nil
+}
+
+// CountKeys 全件をkeysOnlyで読んで数える。集計クエリが使えない環境でも動く。
+// progressがnilでなければlogStep件ごとに呼ぶ
+func CountKeys(ctx context.Context, cl *datastore.Client, q *datastore.Query, logStep int64, progress func(n int64)) (int64, error) {
+	it := cl.Run(ctx, q.KeysOnly())
+	var n int64
+	for {
+		_, err := it.Next(nil)
+		if errors.Is(err, iterator.Done) {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("Next: %w", err)
+		}
+		n++
+		if progress != nil && logStep > 0 && n%logStep == 0 {
+			progress(n)
+		}
+	}
+}
+
+// String 対象のエンティティを表す文字列。保存したカーソルがどのクエリのものか確かめるのに使う
+func (o *QueryOptions) String() string {
+	return fmt.Sprintf("kind=%s, ns=%s, ancestor=%s, filter=[%s], filterJSON=%s, or=%t",
+		*o.Kind, *o.Namespace, o.Ancestor, o.Filters, *o.FilterJSON, *o.Or)
+}
+
+// Count 集計クエリで件数を数える。集計クエリが使えない環境ではCountKeysで数える
+func Count(ctx context.Context, cl *datastore.Client, q *datastore.Query) (int64, error) {
+	res, err := cl.RunAggregationQuery(ctx, q.NewAggregationQuery().WithCount("count"))
+	if status.Code(err) == codes.Unimplemented {
+		return CountKeys(ctx, cl, q, 0, nil)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("RunAggregationQuery: %w", err)
+	}
+	v, ok := res["count"].(*datastorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected aggregation result type: %T", res["count"])
+	}
+	return v.GetIntegerValue(), nil
+}
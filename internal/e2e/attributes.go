@@ -0,0 +1,57 @@
+package e2e
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// publishからreceiveまでのlatencyを測るためにpublisherが付ける属性
+const (
+	AttrRun    = "e2e-run"
+	AttrSeq    = "e2e-seq"
+	AttrSentAt = "e2e-sent-at"
+)
+
+type Stamp struct {
+	// Run publisherの実行とtopicごとに一意なID
+	Run string
+	// Seq Runの中で1から始まる通し番号。publishに失敗したものも番号を使う
+	Seq    int64
+	SentAt time.Time
+}
+
+// Set attrsがnilなら新たに作って返す
+func (s Stamp) Set(attrs map[string]string) map[string]string {
+	if attrs == nil {
+		attrs = make(map[string]string, 3)
+	}
+	attrs[AttrRun] = s.Run
+	attrs[AttrSeq] = strconv.FormatInt(s.Seq, 10)
+	attrs[AttrSentAt] = strconv.FormatInt(s.SentAt.UnixNano(), 10)
+	return attrs
+}
+
+// Parse 属性が付いていなければokはfalse
+func Parse(attrs map[string]string) (s Stamp, ok bool, err error) {
+	run, ok := attrs[AttrRun]
+	if !ok {
+		return Stamp{}, false, nil
+	}
+
+	seq, err := strconv.ParseInt(attrs[AttrSeq], 10, 64)
+	if err != nil {
+		return Stamp{}, true, fmt.Errorf("strconv.ParseInt: %s, %w", AttrSeq, err)
+	}
+
+	sentAt, err := strconv.ParseInt(attrs[AttrSentAt], 10, 64)
+	if err != nil {
+		return Stamp{}, true, fmt.Errorf("strconv.ParseInt: %s, %w", AttrSentAt, err)
+	}
+
+	return Stamp{
+		Run:    run,
+		Seq:    seq,
+		SentAt: time.Unix(0, sentAt),
+	}, true, nil
+}
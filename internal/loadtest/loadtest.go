@@ -0,0 +1,169 @@
+package loadtest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/tckz/go-gcp-playground/internal/pacer"
+	"github.com/tckz/go-gcp-playground/internal/report"
+	vh "github.com/tckz/vegetahelper"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+	"go.uber.org/zap"
+)
+
+// Options 負荷試験コマンドに共通のフラグ
+type Options struct {
+	Rate           *vh.RateFlag
+	Profile        *pacer.Flag
+	Duration       *time.Duration
+	Output         *string
+	Workers        *uint64
+	Report         *string
+	ReportInterval *time.Duration
+
+	// Validateで--profileから作ったもの
+	pacer    vegeta.Pacer
+	duration time.Duration
+}
+
+// NewOptions fsに共通のフラグを登録する。fs.Parseより前に呼ぶこと
+func NewOptions(fs *flag.FlagSet) *Options {
+	o := &Options{
+		Rate: &vh.RateFlag{
+			Rate: &vegeta.Rate{
+				Freq: 30,
+				Per:  1 * time.Second,
+			}},
+		Profile:        &pacer.Flag{},
+		Duration:       fs.Duration("duration", 10*time.Second, "Duration of the test [0 = forever]"),
+		Output:         fs.String("output", "", "/path/to/results.bin or 'stdout'"),
+		Workers:        fs.Uint64("workers", vegeta.DefaultWorkers, "Number of workers"),
+		Report:         fs.String("report", "text", "text|json|none, summary printed to stderr at exit"),
+		ReportInterval: fs.Duration("report-interval", 0, "Interval of interim reports [0 = no interim report]"),
+	}
+	fs.Var(o.Rate, "rate", "Number of requests per time unit")
+	fs.Var(o.Profile, "profile", "ramp:FROM,TO|step:START,STEP,INTERVAL|sine:MEAN,AMP,PERIOD|stages:path/to/file [empty = constant --rate]")
+	return o
+}
+
+// Validate クライアントを作るなどの準備より前に呼んで、--profileの誤りもここで返す
+func (o *Options) Validate() error {
+	if *o.Output == "" {
+		return fmt.Errorf("--output must be specified")
+	}
+	switch *o.Report {
+	case "text", "json", "none":
+	default:
+		return fmt.Errorf("--report must be text, json or none")
+	}
+	if *o.Workers == 0 {
+		return fmt.Errorf("--workers must be > 0")
+	}
+	if o.Profile.Kind == "" && (o.Rate.Rate.Freq <= 0 || o.Rate.Rate.Per <= 0) {
+		return fmt.Errorf("--rate must be > 0")
+	}
+	p, du, err := o.Profile.Pacer(*o.Rate.Rate, *o.Duration)
+	if err != nil {
+		return fmt.Errorf("--profile: %w", err)
+	}
+	o.pacer, o.duration = p, du
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (c nopWriteCloser) Close() error {
+	return nil
+}
+
+func openResultFile(out string) (io.WriteCloser, error) {
+	switch out {
+	case "stdout":
+		return &nopWriteCloser{os.Stdout}, nil
+	default:
+		return os.Create(out)
+	}
+}
+
+// Run fで負荷をかけ、結果を--outputに書き出し、レポートをstderrに出す。
+// SIGINT, SIGTERMを受けたら新たなhitを止めて実行中のhitの終了を待つ
+func (o *Options) Run(ctx context.Context, logger *zap.SugaredLogger, name string, f vh.HitFunc) error {
+	if o.pacer == nil {
+		if err := o.Validate(); err != nil {
+			return err
+		}
+	}
+	p, du := o.pacer, o.duration
+	logger.Infof("pacer=%v, duration=%s", p, du)
+
+	rep, err := report.NewReporter(*o.Report)
+	if err != nil {
+		return fmt.Errorf("report.NewReporter: %w", err)
+	}
+
+	out, err := openResultFile(*o.Output)
+	if err != nil {
+		return fmt.Errorf("openResultFile: %w", err)
+	}
+	defer out.Close()
+	enc := vegeta.NewEncoder(out)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	atk := pacer.NewAttacker(f, *o.Workers)
+	res := atk.Attack(ctx, p, du, name)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	var tick <-chan time.Time
+	if *o.ReportInterval > 0 {
+		t := time.NewTicker(*o.ReportInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	var encErr error
+loop:
+	for {
+		select {
+		case s := <-sig:
+			logger.Infof("Received signal: %s", s)
+			cancel()
+			// keep loop until 'res' is closed.
+		case <-tick:
+			if err := rep.Interim(os.Stderr); err != nil {
+				logger.Errorf("*** Interim: %v", err)
+			}
+		case r, ok := <-res:
+			if !ok {
+				break loop
+			}
+			rep.Add(r)
+			if encErr != nil {
+				continue
+			}
+			if err := enc.Encode(r); err != nil {
+				// attackerが止まるまでresは読み続ける
+				encErr = fmt.Errorf("Encode: %w", err)
+				cancel()
+			}
+		}
+	}
+
+	if err := rep.Final(os.Stderr); err != nil {
+		logger.Errorf("*** Final: %v", err)
+	}
+
+	return encErr
+}
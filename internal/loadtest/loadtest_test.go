@@ -0,0 +1,202 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vh "github.com/tckz/vegetahelper"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+	"go.uber.org/zap"
+)
+
+func newTestOptions(t *testing.T, args ...string) (*Options, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o := NewOptions(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func TestValidate(t *testing.T) {
+	stages := filepath.Join(t.TempDir(), "stages.txt")
+	if err := os.WriteFile(stages, []byte("10/1s 1s\n20/1s 2s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		args         []string
+		wantErr      string
+		wantDuration time.Duration
+	}{
+		{
+			name:         "constant rate",
+			args:         []string{"--output", "stdout"},
+			wantDuration: 10 * time.Second,
+		},
+		{
+			name:    "no output",
+			args:    nil,
+			wantErr: "--output",
+		},
+		{
+			name:    "bad report",
+			args:    []string{"--output", "stdout", "--report", "xml"},
+			wantErr: "--report",
+		},
+		{
+			name:    "no workers",
+			args:    []string{"--output", "stdout", "--workers", "0"},
+			wantErr: "--workers",
+		},
+		{
+			name:    "zero rate",
+			args:    []string{"--output", "stdout", "--rate", "0/1s"},
+			wantErr: "--rate",
+		},
+		{
+			name:         "ramp",
+			args:         []string{"--output", "stdout", "--profile", "ramp:10/1s,100/1s", "--duration", "30s"},
+			wantDuration: 30 * time.Second,
+		},
+		{
+			name:    "ramp without duration",
+			args:    []string{"--output", "stdout", "--profile", "ramp:10/1s,100/1s", "--duration", "0"},
+			wantErr: "requires duration",
+		},
+		{
+			name:    "ramp with missing args",
+			args:    []string{"--output", "stdout", "--profile", "ramp:10/1s"},
+			wantErr: "requires 2 args",
+		},
+		{
+			name:         "stages replace duration",
+			args:         []string{"--output", "stdout", "--profile", "stages:" + stages},
+			wantDuration: 3 * time.Second,
+		},
+		{
+			name:    "stages file not found",
+			args:    []string{"--output", "stdout", "--profile", "stages:" + stages + ".none"},
+			wantErr: "os.Open",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := newTestOptions(t, tt.args...)
+			if err == nil {
+				err = o.Validate()
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if o.pacer == nil {
+				t.Fatal("pacer is not built")
+			}
+			if o.duration != tt.wantDuration {
+				t.Errorf("duration=%s, want %s", o.duration, tt.wantDuration)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	errHit := errors.New("hit failed")
+	tests := []struct {
+		name       string
+		hit        func(n int64) (*vh.HitResult, error)
+		wantCode   uint16
+		wantErrMsg string
+	}{
+		{
+			name: "ok",
+			hit: func(n int64) (*vh.HitResult, error) {
+				return &vh.HitResult{Code: 200, SentBytes: 10}, nil
+			},
+			wantCode: 200,
+		},
+		{
+			name: "error",
+			hit: func(n int64) (*vh.HitResult, error) {
+				return nil, errHit
+			},
+			wantCode:   500,
+			wantErrMsg: errHit.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "results.bin")
+			o, err := newTestOptions(t, "--output", out, "--report", "none", "--rate", "50/1s", "--duration", "400ms")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+
+			var hits int64
+			err = o.Run(context.Background(), zap.NewNop().Sugar(), "test", func(ctx context.Context) (*vh.HitResult, error) {
+				return tt.hit(atomic.AddInt64(&hits, 1))
+			})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+
+			fp, err := os.Open(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close()
+			dec := vegeta.NewDecoder(fp)
+			var n int64
+			for {
+				var r vegeta.Result
+				if err := dec.Decode(&r); err != nil {
+					break
+				}
+				n++
+				if r.Attack != "test" || r.Code != tt.wantCode || r.Error != tt.wantErrMsg {
+					t.Errorf("result=%+v, want attack=test, code=%d, error=%q", r, tt.wantCode, tt.wantErrMsg)
+				}
+			}
+			if n != hits {
+				t.Errorf("results=%d, hits=%d", n, hits)
+			}
+			// 50/sで400ms
+			if hits < 10 || hits > 30 {
+				t.Errorf("hits=%d, want about 20", hits)
+			}
+		})
+	}
+}
+
+func TestRunOutputError(t *testing.T) {
+	o, err := newTestOptions(t, "--output", filepath.Join(t.TempDir(), "no", "such", "dir"), "--report", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Run(context.Background(), zap.NewNop().Sugar(), "test", func(ctx context.Context) (*vh.HitResult, error) {
+		t.Error("hit must not be called")
+		return nil, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "openResultFile") {
+		t.Fatalf("err=%v, want openResultFile error", err)
+	}
+}
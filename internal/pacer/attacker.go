@@ -0,0 +1,83 @@
+package pacer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	vh "github.com/tckz/vegetahelper"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// sliceDuration 一定レートでないPacerはこの長さの区間ごとにレートを決め直す
+const sliceDuration = 100 * time.Millisecond
+
+// Attacker vegetahelper.Attackerは一定レートしか扱えないので、
+// 短い区間ごとにPacerのレートで一定レートの攻撃を繰り返す
+type Attacker struct {
+	atk   *vh.Attacker
+	slice time.Duration
+}
+
+func NewAttacker(f vh.HitFunc, workers uint64) *Attacker {
+	return &Attacker{
+		atk:   vh.NewAttacker(f, vh.WithWorkers(workers)),
+		slice: sliceDuration,
+	}
+}
+
+// Attack du=0ならPacerが止まるかctxがcancelされるまで続ける
+func (a *Attacker) Attack(ctx context.Context, p vegeta.Pacer, du time.Duration, name string) <-chan *vegeta.Result {
+	if r, ok := p.(vegeta.Rate); ok {
+		return a.atk.Attack(ctx, r, du, name)
+	}
+
+	results := make(chan *vegeta.Result)
+	go func() {
+		var wg sync.WaitGroup
+		defer close(results)
+		defer wg.Wait()
+
+		began := time.Now()
+		// carry 前の区間までで端数になったhit数
+		var carry float64
+		for start := time.Duration(0); du <= 0 || start < du; start += a.slice {
+			if _, stop := p.Pace(start, 0); stop {
+				return
+			}
+			sl := a.slice
+			if du > 0 {
+				sl = min(sl, du-start)
+			}
+
+			select {
+			case <-time.After(time.Until(began.Add(start))):
+			case <-ctx.Done():
+				return
+			}
+
+			// 区間の中央のレートで区間全体を代表させる
+			hits := p.Rate(start+sl/2)*sl.Seconds() + carry
+			n := int(hits)
+			carry = hits - float64(n)
+			if n <= 0 {
+				continue
+			}
+			// Perをnで割り切れるようにしておかないとvegetahelper側でhit数がずれる
+			per := sl / time.Duration(n) * time.Duration(n)
+			if per <= 0 {
+				per, n = sl, int(sl)
+			}
+
+			res := a.atk.Attack(ctx, vegeta.Rate{Freq: n, Per: per}, per, name)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for r := range res {
+					results <- r
+				}
+			}()
+		}
+	}()
+	return results
+}
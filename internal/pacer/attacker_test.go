@@ -0,0 +1,78 @@
+package pacer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vh "github.com/tckz/vegetahelper"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestAttackerHits(t *testing.T) {
+	tests := []struct {
+		name string
+		p    vegeta.Pacer
+		du   time.Duration
+		min  int
+		max  int
+	}{
+		{name: "constant", p: vegeta.Rate{Freq: 100, Per: time.Second}, du: 300 * time.Millisecond, min: 29, max: 31},
+		{
+			name: "stages",
+			p: StagePacer{
+				{Rate: vegeta.Rate{Freq: 100, Per: time.Second}, Duration: 200 * time.Millisecond},
+				{Rate: vegeta.Rate{Freq: 0, Per: time.Second}, Duration: 100 * time.Millisecond},
+				{Rate: vegeta.Rate{Freq: 200, Per: time.Second}, Duration: 200 * time.Millisecond},
+			},
+			du:  500 * time.Millisecond,
+			min: 58, max: 62,
+		},
+		{
+			name: "ramp",
+			p:    vegeta.LinearPacer{StartAt: vegeta.Rate{Freq: 100, Per: time.Second}, Slope: 500},
+			du:   400 * time.Millisecond,
+			// 100/sから300/sまで0.4sで増えるので平均200/s
+			min: 76, max: 84,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int64
+			a := NewAttacker(func(ctx context.Context) (*vh.HitResult, error) {
+				hits.Add(1)
+				return &vh.HitResult{Code: 200}, nil
+			}, 4)
+			var results int
+			for r := range a.Attack(context.Background(), tt.p, tt.du, tt.name) {
+				if r.Attack != tt.name {
+					t.Errorf("Attack=%s, want %s", r.Attack, tt.name)
+				}
+				results++
+			}
+			if results < tt.min || results > tt.max {
+				t.Errorf("results=%d, want %d..%d", results, tt.min, tt.max)
+			}
+			if int(hits.Load()) != results {
+				t.Errorf("hits=%d, results=%d", hits.Load(), results)
+			}
+		})
+	}
+}
+
+func TestAttackerCancel(t *testing.T) {
+	a := NewAttacker(func(ctx context.Context) (*vh.HitResult, error) {
+		return &vh.HitResult{Code: 200}, nil
+	}, 1)
+	p := StagePacer{{Rate: vegeta.Rate{Freq: 10, Per: time.Second}, Duration: time.Hour}}
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	begin := time.Now()
+	for range a.Attack(ctx, p, 0, "cancel") {
+	}
+	if el := time.Since(begin); el > time.Second {
+		t.Errorf("Attack took %s after cancel", el)
+	}
+}
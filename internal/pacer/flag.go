@@ -0,0 +1,197 @@
+package pacer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	vh "github.com/tckz/vegetahelper"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Flag 負荷のかけ方を指定するフラグ。空なら--rateの一定レート
+//
+//	ramp:FROM,TO                 FROMからTOまで--durationをかけて線形に増やす
+//	step:START,STEP,INTERVAL     STARTからINTERVALごとにFreqをSTEPずつ増やす
+//	sine:MEAN,AMP,PERIOD         MEANを中心に振幅AMP、周期PERIODで変化させる
+//	stages:path/to/file          1行に"RATE DURATION"を並べたファイル
+//
+// RATEは--rateと同じ書式(50/1s)
+type Flag struct {
+	Kind string
+	Args []string
+}
+
+func (f *Flag) String() string {
+	if f.Kind == "" {
+		return ""
+	}
+	return f.Kind + ":" + strings.Join(f.Args, ",")
+}
+
+func (f *Flag) Set(v string) error {
+	kind, args, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("profile %q doesn't match the \"kind:args\" format", v)
+	}
+
+	switch kind {
+	case "ramp", "step", "sine":
+		f.Args = strings.Split(args, ",")
+	case "stages":
+		f.Args = []string{args}
+	default:
+		return fmt.Errorf("unknown profile: %s", kind)
+	}
+	f.Kind = kind
+	return nil
+}
+
+func parseRate(s string) (vegeta.Rate, error) {
+	f := vh.RateFlag{Rate: &vegeta.Rate{}}
+	if err := f.Set(strings.TrimSpace(s)); err != nil {
+		return vegeta.Rate{}, fmt.Errorf("rate %q: %w", s, err)
+	}
+	return *f.Rate, nil
+}
+
+func perSecond(r vegeta.Rate) float64 {
+	return float64(r.Freq) / r.Per.Seconds()
+}
+
+// Pacer rateとduは--rateと--durationの値。durationはstagesの場合はその合計に置き換えたものを返す
+func (f *Flag) Pacer(rate vegeta.Rate, du time.Duration) (vegeta.Pacer, time.Duration, error) {
+	needArgs := func(n int) error {
+		if len(f.Args) != n {
+			return fmt.Errorf("profile %s requires %d args: %s", f.Kind, n, f)
+		}
+		return nil
+	}
+
+	switch f.Kind {
+	case "":
+		return rate, du, nil
+	case "ramp":
+		if err := needArgs(2); err != nil {
+			return nil, 0, err
+		}
+		if du <= 0 {
+			return nil, 0, fmt.Errorf("profile ramp requires duration > 0")
+		}
+		from, err := parseRate(f.Args[0])
+		if err != nil {
+			return nil, 0, err
+		}
+		to, err := parseRate(f.Args[1])
+		if err != nil {
+			return nil, 0, err
+		}
+		if from.Freq <= 0 {
+			// LinearPacerはStartAtが0だと無制限になってしまう
+			return nil, 0, fmt.Errorf("profile ramp requires FROM > 0")
+		}
+		return vegeta.LinearPacer{
+			StartAt: from,
+			Slope:   (perSecond(to) - perSecond(from)) / du.Seconds(),
+		}, du, nil
+	case "step":
+		if err := needArgs(3); err != nil {
+			return nil, 0, err
+		}
+		if du <= 0 {
+			return nil, 0, fmt.Errorf("profile step requires duration > 0")
+		}
+		start, err := parseRate(f.Args[0])
+		if err != nil {
+			return nil, 0, err
+		}
+		step, err := strconv.Atoi(strings.TrimSpace(f.Args[1]))
+		if err != nil {
+			return nil, 0, fmt.Errorf("step %q: %w", f.Args[1], err)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(f.Args[2]))
+		if err != nil || interval <= 0 {
+			return nil, 0, fmt.Errorf("interval %q must be positive duration", f.Args[2])
+		}
+		var p StagePacer
+		for d, r := time.Duration(0), start; d < du; d += interval {
+			p = append(p, Stage{Rate: r, Duration: min(interval, du-d)})
+			r.Freq = max(0, r.Freq+step)
+		}
+		return p, du, nil
+	case "sine":
+		if err := needArgs(3); err != nil {
+			return nil, 0, err
+		}
+		mean, err := parseRate(f.Args[0])
+		if err != nil {
+			return nil, 0, err
+		}
+		amp, err := parseRate(f.Args[1])
+		if err != nil {
+			return nil, 0, err
+		}
+		period, err := time.ParseDuration(strings.TrimSpace(f.Args[2]))
+		if err != nil || period <= 0 {
+			return nil, 0, fmt.Errorf("period %q must be positive duration", f.Args[2])
+		}
+		if perSecond(amp) >= perSecond(mean) {
+			return nil, 0, fmt.Errorf("profile sine requires AMP < MEAN")
+		}
+		return vegeta.SinePacer{
+			Period:  period,
+			Mean:    mean,
+			Amp:     amp,
+			StartAt: vegeta.MeanUp,
+		}, du, nil
+	case "stages":
+		p, err := LoadStages(f.Args[0])
+		if err != nil {
+			return nil, 0, err
+		}
+		return p, p.Duration(), nil
+	default:
+		return nil, 0, fmt.Errorf("unknown profile: %s", f.Kind)
+	}
+}
+
+// LoadStages 空行と#から始まる行は無視する
+func LoadStages(path string) (StagePacer, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer fp.Close()
+
+	var p StagePacer
+	sc := bufio.NewScanner(fp)
+	for ln := 1; sc.Scan(); ln++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: must be \"RATE DURATION\"", path, ln)
+		}
+		r, err := parseRate(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, ln, err)
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s:%d: duration %q must be positive duration", path, ln, fields[1])
+		}
+		p = append(p, Stage{Rate: r, Duration: d})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("Scan: %w", err)
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("%s: no stages", path)
+	}
+	return p, nil
+}
@@ -0,0 +1,203 @@
+package pacer
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestFlagSet(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantKind string
+		wantArgs []string
+		wantErr  string
+	}{
+		{in: "ramp:10/1s,100/1s", wantKind: "ramp", wantArgs: []string{"10/1s", "100/1s"}},
+		{in: "step:10/1s,5,10s", wantKind: "step", wantArgs: []string{"10/1s", "5", "10s"}},
+		{in: "sine:100/1s,50/1s,1m", wantKind: "sine", wantArgs: []string{"100/1s", "50/1s", "1m"}},
+		{in: "stages:path/to/a,b", wantKind: "stages", wantArgs: []string{"path/to/a,b"}},
+		{in: "ramp", wantErr: "kind:args"},
+		{in: "", wantErr: "kind:args"},
+		{in: "square:1,2", wantErr: "unknown profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var f Flag
+			err := f.Set(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if f.Kind != tt.wantKind || strings.Join(f.Args, "|") != strings.Join(tt.wantArgs, "|") {
+				t.Errorf("kind=%s, args=%v, want %s, %v", f.Kind, f.Args, tt.wantKind, tt.wantArgs)
+			}
+			if f.String() != tt.in {
+				t.Errorf("String=%s, want %s", f.String(), tt.in)
+			}
+		})
+	}
+}
+
+func TestFlagPacerErrors(t *testing.T) {
+	rate := vegeta.Rate{Freq: 10, Per: time.Second}
+	tests := []struct {
+		profile string
+		du      time.Duration
+		wantErr string
+	}{
+		{profile: "ramp:10/1s", du: time.Minute, wantErr: "requires 2 args"},
+		{profile: "ramp:10/1s,100/1s", du: 0, wantErr: "duration > 0"},
+		{profile: "ramp:0/1s,100/1s", du: time.Minute, wantErr: "FROM > 0"},
+		{profile: "ramp:x,100/1s", du: time.Minute, wantErr: `rate "x"`},
+		{profile: "step:10/1s,5", du: time.Minute, wantErr: "requires 3 args"},
+		{profile: "step:10/1s,x,10s", du: time.Minute, wantErr: `step "x"`},
+		{profile: "step:10/1s,5,0s", du: time.Minute, wantErr: "positive duration"},
+		{profile: "step:10/1s,5,10s", du: 0, wantErr: "duration > 0"},
+		{profile: "sine:10/1s,20/1s,1m", du: time.Minute, wantErr: "AMP < MEAN"},
+		{profile: "sine:10/1s,5/1s,-1m", du: time.Minute, wantErr: "positive duration"},
+		{profile: "stages:/no/such/file", du: time.Minute, wantErr: "os.Open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			var f Flag
+			if err := f.Set(tt.profile); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			_, _, err := f.Pacer(rate, tt.du)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFlagPacerRamp(t *testing.T) {
+	f := Flag{}
+	if err := f.Set("ramp:10/1s,110/1s"); err != nil {
+		t.Fatal(err)
+	}
+	p, du, err := f.Pacer(vegeta.Rate{}, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Pacer: %v", err)
+	}
+	if du != 10*time.Second {
+		t.Errorf("du=%s, want 10s", du)
+	}
+	// 10/sから110/sまで10sで線形に増える
+	for _, tt := range []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 10},
+		{2500 * time.Millisecond, 35},
+		{5 * time.Second, 60},
+		{10 * time.Second, 110},
+	} {
+		if got := p.Rate(tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Rate(%s)=%f, want %f", tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestFlagPacerStep(t *testing.T) {
+	f := Flag{}
+	if err := f.Set("step:10/1s,-4,4s"); err != nil {
+		t.Fatal(err)
+	}
+	p, du, err := f.Pacer(vegeta.Rate{}, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Pacer: %v", err)
+	}
+	if du != 10*time.Second {
+		t.Errorf("du=%s, want 10s", du)
+	}
+	// 最後のStageは--durationで切られ、Freqは0より小さくならない
+	want := StagePacer{
+		{Rate: vegeta.Rate{Freq: 10, Per: time.Second}, Duration: 4 * time.Second},
+		{Rate: vegeta.Rate{Freq: 6, Per: time.Second}, Duration: 4 * time.Second},
+		{Rate: vegeta.Rate{Freq: 2, Per: time.Second}, Duration: 2 * time.Second},
+	}
+	if got, ok := p.(StagePacer); !ok || got.String() != want.String() {
+		t.Errorf("pacer=%v, want %v", p, want)
+	}
+
+	if err := f.Set("step:5/1s,-10,1s"); err != nil {
+		t.Fatal(err)
+	}
+	p, _, err = f.Pacer(vegeta.Rate{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Pacer: %v", err)
+	}
+	if r := p.Rate(1500 * time.Millisecond); r != 0 {
+		t.Errorf("Rate=%f, want 0", r)
+	}
+}
+
+func TestFlagPacerConstantAndStages(t *testing.T) {
+	rate := vegeta.Rate{Freq: 10, Per: time.Second}
+	var f Flag
+	p, du, err := f.Pacer(rate, time.Minute)
+	if err != nil {
+		t.Fatalf("Pacer: %v", err)
+	}
+	if p != rate || du != time.Minute {
+		t.Errorf("pacer=%v, du=%s, want --rate and --duration as is", p, du)
+	}
+
+	path := filepath.Join(t.TempDir(), "stages.txt")
+	content := "# warm up\n10/1s 10s\n\n0/1s 5s\n100/1s 1m\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("stages:" + path); err != nil {
+		t.Fatal(err)
+	}
+	p, du, err = f.Pacer(rate, time.Minute)
+	if err != nil {
+		t.Fatalf("Pacer: %v", err)
+	}
+	// durationはstagesの合計になる
+	if du != 75*time.Second {
+		t.Errorf("du=%s, want 1m15s", du)
+	}
+	if n := len(p.(StagePacer)); n != 3 {
+		t.Errorf("stages=%d, want 3", n)
+	}
+}
+
+func TestLoadStagesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "empty", content: "# nothing\n", wantErr: "no stages"},
+		{name: "fields", content: "10/1s\n", wantErr: ":1: must be"},
+		{name: "rate", content: "10/1s 1s\nfast 1s\n", wantErr: `:2: rate "fast"`},
+		{name: "zero duration", content: "10/1s 0s\n", wantErr: ":1: duration"},
+		{name: "bad duration", content: "10/1s 1x\n", wantErr: ":1: duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stages.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadStages(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err=%v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
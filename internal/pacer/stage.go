@@ -0,0 +1,81 @@
+package pacer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+type Stage struct {
+	Rate     vegeta.Rate
+	Duration time.Duration
+}
+
+func (s Stage) hitsPerNs() float64 {
+	if s.Rate.Per <= 0 || s.Rate.Freq <= 0 {
+		return 0
+	}
+	return float64(s.Rate.Freq) / float64(s.Rate.Per)
+}
+
+// StagePacer Stageを順に実行し、最後のStageが終わったら止まる。Freqが0のStageは休止
+type StagePacer []Stage
+
+var _ vegeta.Pacer = StagePacer{}
+
+func (p StagePacer) String() string {
+	s := make([]string, 0, len(p))
+	for _, e := range p {
+		s = append(s, fmt.Sprintf("%d/%s for %s", e.Rate.Freq, e.Rate.Per, e.Duration))
+	}
+	return "Stage{" + strings.Join(s, ", ") + "}"
+}
+
+func (p StagePacer) Duration() time.Duration {
+	var d time.Duration
+	for _, e := range p {
+		d += e.Duration
+	}
+	return d
+}
+
+func (p StagePacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	var start time.Duration
+	// base 現在のStageより前に送っているべきhit数
+	var base float64
+	for _, e := range p {
+		end := start + e.Duration
+		if elapsed < end {
+			hitsPerNs := e.hitsPerNs()
+			if hitsPerNs == 0 {
+				return end - elapsed, false
+			}
+
+			expected := base + hitsPerNs*float64(elapsed-start)
+			if float64(hits) < expected {
+				// Running behind, send next hit immediately.
+				return 0, false
+			}
+
+			wait := start + time.Duration((float64(hits+1)-base)/hitsPerNs) - elapsed
+			// 次のStageにまたがる場合はStageの境界で改めて計算する
+			return min(wait, end-elapsed), false
+		}
+		base += e.hitsPerNs() * float64(e.Duration)
+		start = end
+	}
+	return 0, true
+}
+
+func (p StagePacer) Rate(elapsed time.Duration) float64 {
+	var start time.Duration
+	for _, e := range p {
+		start += e.Duration
+		if elapsed < start {
+			return e.hitsPerNs() * 1e9
+		}
+	}
+	return 0
+}
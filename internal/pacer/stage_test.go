@@ -0,0 +1,90 @@
+package pacer
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+func TestStagePacerPace(t *testing.T) {
+	// 10/sを1s、休止を1s、20/sを1s
+	p := StagePacer{
+		{Rate: vegeta.Rate{Freq: 10, Per: time.Second}, Duration: time.Second},
+		{Rate: vegeta.Rate{Freq: 0, Per: time.Second}, Duration: time.Second},
+		{Rate: vegeta.Rate{Freq: 20, Per: time.Second}, Duration: time.Second},
+	}
+	tests := []struct {
+		name     string
+		elapsed  time.Duration
+		hits     uint64
+		wantWait time.Duration
+		wantStop bool
+	}{
+		// vegetaのConstantPacerと同じく最初のhitは1間隔後
+		{name: "start", elapsed: 0, hits: 0, wantWait: 100 * time.Millisecond},
+		{name: "on schedule", elapsed: 100 * time.Millisecond, hits: 1, wantWait: 100 * time.Millisecond},
+		{name: "ahead", elapsed: 150 * time.Millisecond, hits: 3, wantWait: 250 * time.Millisecond},
+		{name: "behind", elapsed: 500 * time.Millisecond, hits: 2, wantWait: 0},
+		{name: "wait is cut at the stage boundary", elapsed: 950 * time.Millisecond, hits: 10, wantWait: 50 * time.Millisecond},
+		{name: "pause", elapsed: 1200 * time.Millisecond, hits: 10, wantWait: 800 * time.Millisecond},
+		{name: "behind at the boundary", elapsed: 2 * time.Second, hits: 9, wantWait: 0},
+		{name: "boundary starts next stage", elapsed: 2 * time.Second, hits: 10, wantWait: 50 * time.Millisecond},
+		{name: "third stage", elapsed: 2050 * time.Millisecond, hits: 11, wantWait: 50 * time.Millisecond},
+		{name: "end", elapsed: 3 * time.Second, hits: 30, wantStop: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, stop := p.Pace(tt.elapsed, tt.hits)
+			if stop != tt.wantStop {
+				t.Fatalf("stop=%t, want %t", stop, tt.wantStop)
+			}
+			if wait != tt.wantWait {
+				t.Errorf("wait=%s, want %s", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestStagePacerZeroDurationStage(t *testing.T) {
+	p := StagePacer{
+		{Rate: vegeta.Rate{Freq: 10, Per: time.Second}, Duration: time.Second},
+		{Rate: vegeta.Rate{Freq: 1000, Per: time.Second}, Duration: 0},
+		{Rate: vegeta.Rate{Freq: 20, Per: time.Second}, Duration: time.Second},
+	}
+	if d := p.Duration(); d != 2*time.Second {
+		t.Errorf("Duration=%s, want 2s", d)
+	}
+	// 長さ0のStageは飛ばして次のStageのレートになる
+	if r := p.Rate(time.Second); r != 20 {
+		t.Errorf("Rate=%f, want 20", r)
+	}
+	if wait, stop := p.Pace(time.Second, 11); stop || wait != 100*time.Millisecond {
+		t.Errorf("Pace=%s, %t, want 100ms, false", wait, stop)
+	}
+	if _, stop := p.Pace(2*time.Second, 30); !stop {
+		t.Errorf("Pace must stop at the end")
+	}
+}
+
+func TestStagePacerRate(t *testing.T) {
+	p := StagePacer{
+		{Rate: vegeta.Rate{Freq: 10, Per: time.Second}, Duration: time.Second},
+		{Rate: vegeta.Rate{Freq: 30, Per: time.Minute}, Duration: time.Second},
+	}
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 10},
+		{999 * time.Millisecond, 10},
+		{time.Second, 0.5},
+		{2 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		if got := p.Rate(tt.elapsed); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Rate(%s)=%f, want %f", tt.elapsed, got, tt.want)
+		}
+	}
+}
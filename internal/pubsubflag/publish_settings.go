@@ -0,0 +1,76 @@
+package pubsubflag
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// PublishSettings PublishSettingsを組み立てるためのフラグ
+type PublishSettings struct {
+	NumGoroutines     *int
+	DelayThreshold    *time.Duration
+	CountThreshold    *int
+	ByteThreshold     *int
+	Timeout           *time.Duration
+	MaxOutstandingMsg *int
+	MaxOutstandingB   *int
+	LimitExceeded     *string
+	Compression       *bool
+	CompressionBytes  *int
+}
+
+// NewPublishSettings fsにPublishSettingsのフラグを登録する。fs.Parseより前に呼ぶこと
+func NewPublishSettings(fs *flag.FlagSet) *PublishSettings {
+	d := pubsub.DefaultPublishSettings
+	return &PublishSettings{
+		NumGoroutines:     fs.Int("num-goroutines", 30, "PublishSettings.NumGoroutines"),
+		DelayThreshold:    fs.Duration("delay-threshold", d.DelayThreshold, "PublishSettings.DelayThreshold"),
+		CountThreshold:    fs.Int("count-threshold", d.CountThreshold, "PublishSettings.CountThreshold"),
+		ByteThreshold:     fs.Int("byte-threshold", d.ByteThreshold, "PublishSettings.ByteThreshold"),
+		Timeout:           fs.Duration("publish-timeout", d.Timeout, "PublishSettings.Timeout"),
+		MaxOutstandingMsg: fs.Int("max-outstanding-messages", d.FlowControlSettings.MaxOutstandingMessages, "FlowControlSettings.MaxOutstandingMessages [<= 0 = disabled]"),
+		MaxOutstandingB:   fs.Int("max-outstanding-bytes", d.FlowControlSettings.MaxOutstandingBytes, "FlowControlSettings.MaxOutstandingBytes [<= 0 = disabled]"),
+		LimitExceeded:     fs.String("limit-exceeded", "ignore", "ignore|block|signal-error, FlowControlSettings.LimitExceededBehavior"),
+		Compression:       fs.Bool("compression", d.EnableCompression, "PublishSettings.EnableCompression"),
+		CompressionBytes:  fs.Int("compression-bytes-threshold", d.CompressionBytesThreshold, "PublishSettings.CompressionBytesThreshold"),
+	}
+}
+
+func parseLimitExceededBehavior(s string) (pubsub.LimitExceededBehavior, error) {
+	switch s {
+	case "ignore":
+		return pubsub.FlowControlIgnore, nil
+	case "block":
+		return pubsub.FlowControlBlock, nil
+	case "signal-error":
+		return pubsub.FlowControlSignalError, nil
+	default:
+		return 0, fmt.Errorf("unknown limit exceeded behavior: %s", s)
+	}
+}
+
+// Settings フラグからPublishSettingsを組み立てる
+func (f *PublishSettings) Settings() (pubsub.PublishSettings, error) {
+	behavior, err := parseLimitExceededBehavior(*f.LimitExceeded)
+	if err != nil {
+		return pubsub.PublishSettings{}, err
+	}
+
+	ps := pubsub.DefaultPublishSettings
+	ps.NumGoroutines = *f.NumGoroutines
+	ps.DelayThreshold = *f.DelayThreshold
+	ps.CountThreshold = *f.CountThreshold
+	ps.ByteThreshold = *f.ByteThreshold
+	ps.Timeout = *f.Timeout
+	ps.FlowControlSettings = pubsub.FlowControlSettings{
+		MaxOutstandingMessages: *f.MaxOutstandingMsg,
+		MaxOutstandingBytes:    *f.MaxOutstandingB,
+		LimitExceededBehavior:  behavior,
+	}
+	ps.EnableCompression = *f.Compression
+	ps.CompressionBytesThreshold = *f.CompressionBytes
+	return ps, nil
+}
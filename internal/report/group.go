@@ -0,0 +1,69 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Group 名前ごとにReportを分けて集計する。goroutine safe
+type Group struct {
+	mu      sync.Mutex
+	reports map[string]*Report
+}
+
+func NewGroup() *Group {
+	return &Group{
+		reports: map[string]*Report{},
+	}
+}
+
+func (g *Group) Add(name string, res *vegeta.Result) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	r, ok := g.reports[name]
+	if !ok {
+		r = New()
+		g.reports[name] = r
+	}
+	r.Add(res)
+}
+
+// Write formatは text|json
+func (g *Group) Write(w io.Writer, format string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch format {
+	case "text":
+		names := make([]string, 0, len(g.reports))
+		for k := range g.reports {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			if _, err := fmt.Fprintf(w, "[%s]\n", k); err != nil {
+				return err
+			}
+			if err := g.reports[k].Write(w, format); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		for _, r := range g.reports {
+			r.Metrics.Close()
+		}
+		if err := json.NewEncoder(w).Encode(g.reports); err != nil {
+			return fmt.Errorf("json.Encode: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
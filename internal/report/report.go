@@ -0,0 +1,117 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Report vegetaのMetricsに加えてエラーごとの件数を集計する
+type Report struct {
+	Metrics vegeta.Metrics `json:"metrics"`
+	// ErrorCounts エラーメッセージごとの件数
+	ErrorCounts map[string]int `json:"error_counts"`
+}
+
+func New() *Report {
+	return &Report{
+		ErrorCounts: map[string]int{},
+	}
+}
+
+func (r *Report) Add(res *vegeta.Result) {
+	r.Metrics.Add(res)
+	if res.Error != "" {
+		r.ErrorCounts[res.Error]++
+	}
+}
+
+// Write formatは text|json。Closeして集計値を確定させてから書く
+func (r *Report) Write(w io.Writer, format string) error {
+	r.Metrics.Close()
+
+	switch format {
+	case "text":
+		if err := vegeta.NewTextReporter(&r.Metrics).Report(w); err != nil {
+			return fmt.Errorf("TextReporter: %w", err)
+		}
+		if len(r.ErrorCounts) == 0 {
+			return nil
+		}
+		if _, err := fmt.Fprintln(w, "Error Counts:"); err != nil {
+			return err
+		}
+		errs := make([]string, 0, len(r.ErrorCounts))
+		for k := range r.ErrorCounts {
+			errs = append(errs, k)
+		}
+		sort.Slice(errs, func(i, j int) bool {
+			if r.ErrorCounts[errs[i]] != r.ErrorCounts[errs[j]] {
+				return r.ErrorCounts[errs[i]] > r.ErrorCounts[errs[j]]
+			}
+			return errs[i] < errs[j]
+		})
+		for _, e := range errs {
+			if _, err := fmt.Fprintf(w, "%d\t%s\n", r.ErrorCounts[e], e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		if err := json.NewEncoder(w).Encode(r); err != nil {
+			return fmt.Errorf("json.Encode: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
+
+// Reporter 全体の集計と、定期的に出力してはリセットする途中経過の集計を持つ
+type Reporter struct {
+	format  string
+	total   *Report
+	interim *Report
+}
+
+// NewReporter formatが"none"なら何も出力しない
+func NewReporter(format string) (*Reporter, error) {
+	switch format {
+	case "text", "json", "none":
+	default:
+		return nil, fmt.Errorf("unknown report format: %s", format)
+	}
+	return &Reporter{
+		format:  format,
+		total:   New(),
+		interim: New(),
+	}, nil
+}
+
+func (r *Reporter) Add(res *vegeta.Result) {
+	if r.format == "none" {
+		return
+	}
+	r.total.Add(res)
+	r.interim.Add(res)
+}
+
+// Interim 前回のInterimからの集計を書く
+func (r *Reporter) Interim(w io.Writer) error {
+	if r.format == "none" {
+		return nil
+	}
+	rep := r.interim
+	r.interim = New()
+	return rep.Write(w, r.format)
+}
+
+func (r *Reporter) Final(w io.Writer) error {
+	if r.format == "none" {
+		return nil
+	}
+	return r.total.Write(w, r.format)
+}